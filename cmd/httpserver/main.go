@@ -1,44 +1,24 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
+	"time"
 
-	"github.com/kalim-Asim/http-server/internal/headers"
+	"github.com/kalim-Asim/http-server/internal/fileserver"
 	"github.com/kalim-Asim/http-server/internal/request"
 	"github.com/kalim-Asim/http-server/internal/response"
+	"github.com/kalim-Asim/http-server/internal/router"
 	"github.com/kalim-Asim/http-server/internal/server"
 )
 
 const port = 42069
-const BadRequest = `
-<html>
-  <head>
-    <title>400 Bad Request</title>
-  </head>
-  <body>
-    <h1>Bad Request</h1>
-    <p>Your request honestly kinda sucked.</p>
-  </body>
-</html>
-`
-const InternalServerError = `
-<html>
-  <head>
-    <title>500 Internal Server Error</title>
-  </head>
-  <body>
-    <h1>Internal Server Error</h1>
-    <p>Okay, you know what? This one is on me.</p>
-  </body>
-</html>
-`
 const StatusOk = `
 <html>
   <head>
@@ -52,100 +32,125 @@ const StatusOk = `
 `
 
 func main() {
-	server, err := server.Serve(
-		port,
-		func(w *response.Writer, req *request.Request) {
-			h := response.GetDefaultHeaders(0)
-			body := []byte("All good, frfr\n")
-			var status response.StatusCode = response.StatusOK
-
-			if req.RequestLine.RequestTarget == "/yourproblem" {
-				body = []byte(BadRequest)
-				status = response.StatusBadRequest
-
-			} else if req.RequestLine.RequestTarget == "/myproblem" {
-				body = []byte(InternalServerError)
-				status = response.StatusInternalServerError
-
-			} else if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/stream") {
-				target := req.RequestLine.RequestTarget
-				res, err := http.Get("https://httpbin.org/" + target[len("/httpbin/"):])
-				if err != nil {
-					body = []byte(InternalServerError)
-					status = response.StatusInternalServerError
-					return
-				}
-				defer res.Body.Close()
-
-				w.WriteStatusLine(status)
-
-				h.Delete("Content-Length")
-				h.Set("Transfer-Encoding", "chunked")
-				h.Set("Content-Type", "text/plain")
-				h.Set("Trailer", "X-Content-SHA256, X-Content-Length")
-
-				w.WriteHeaders(*h)
-
-				fullBody := []byte{}
-
-				buf := make([]byte, 32)
-				for {
-					n, err := res.Body.Read(buf)
-					if n > 0 {
-						fullBody = append(fullBody, buf[:n]...)
-						w.WriteChunkedBody(buf[:n])
-					}
-					if err != nil {
-						break
-					}
-				}
-
-				w.WriteChunkedBodyDone()
-
-				trailers := headers.NewHeaders()
-				hash := sha256.Sum256(fullBody)
-				trailers.Set("X-Content-SHA256", toString(hash[:]))
-				trailers.Set("X-Content-Length", fmt.Sprintf("%d", len(fullBody)))
-
-				w.WriteTrailers(trailers, nil)
-				return 
-
-			} else if req.RequestLine.RequestTarget == "/video" {
-				video, err := os.ReadFile("assets/vim.mp4")
-				if err != nil {
-					body = []byte(InternalServerError)
-					status = response.StatusInternalServerError
-					return 
-				} 
-				h.Set("Content-Type", "video/mp4")
-				h.Set("Content-Length", fmt.Sprintf("%d", len(video)))
-
-				w.WriteStatusLine(status)
-				w.WriteHeaders(*h)
-				w.WriteBody(video)
-				return 
-			}
-
-			h.Set("Content-Length", fmt.Sprintf("%d", len(body)))
-			h.Set("Content-Type", "text/html")
-			w.WriteStatusLine(status)
-			w.WriteHeaders(*h)
-			w.WriteBody(body)
-		})
+	r := router.New()
+	r.Get("/", handleRoot)
+	r.Get("/yourproblem", server.HandleErrors(handleYourProblem))
+	r.Get("/myproblem", server.HandleErrors(handleMyProblem))
+	r.Get("/video", handleVideo)
+
+	httpbin := r.Group("/httpbin")
+	httpbin.Get("/stream/{n}", server.HandleErrors(handleStream))
+
+	handler := server.Chain(r.Serve,
+		server.Recover(),
+		server.RequestID(),
+		server.Timing(),
+	)
+
+	server, err := server.Serve(port, handler,
+		server.WithReadHeaderTimeout(10*time.Second),
+		server.WithReadTimeout(30*time.Second),
+		server.WithIdleTimeout(2*time.Minute),
+	)
 
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
 
-	defer server.Close()
 	log.Println("Server started on port", port)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	// give in-flight requests a moment to finish
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	forced, err := server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("Server stopped, %d connection(s) forcibly closed", forced)
+		return
+	}
 	log.Println("Server gracefully stopped")
 }
 
+// writes a complete html response
+func writeHTML(w *response.Writer, status response.StatusCode, body []byte) {
+	aw := response.NewAutoWriter(w)
+	aw.Header().Set("Content-Type", "text/html")
+	aw.WriteHeader(status)
+	aw.Write(body)
+	aw.Close()
+}
+
+func handleRoot(w *response.Writer, req *request.Request) {
+	writeHTML(w, response.StatusOK, []byte("All good, frfr\n"))
+}
+
+func handleYourProblem(w *response.Writer, req *request.Request) error {
+	return server.Errorf(response.StatusBadRequest, "Your request honestly kinda sucked.")
+}
+
+func handleMyProblem(w *response.Writer, req *request.Request) error {
+	return server.Errorf(response.StatusInternalServerError, "Okay, you know what? This one is on me.")
+}
+
+// proxies httpbin.org/stream/{n} as a chunked response with trailers
+func handleStream(w *response.Writer, req *request.Request) error {
+	h := response.GetDefaultHeaders(0)
+	status := response.StatusOK
+
+	// the upstream call ends when our client goes away
+	upstream, err := http.NewRequestWithContext(req.Context(), "GET", "https://httpbin.org/stream/"+req.PathParam("n"), nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(upstream)
+	if err != nil {
+		return server.Errorf(response.StatusBadGateway, "upstream unreachable")
+	}
+	defer res.Body.Close()
+
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Content-Type", "text/plain")
+	h.Set("Trailer", "X-Content-SHA256, X-Content-Length")
+
+	w.WriteStatusLine(status)
+	if err := w.WriteHeaders(*h); err != nil {
+		return err
+	}
+
+	cw := response.NewChunkedWriter(w)
+	hash := sha256.New()
+	size := 0
+
+	// every upstream read is passed on as its own chunk
+	buf := make([]byte, 32)
+	for {
+		n, err := res.Body.Read(buf)
+		if n > 0 {
+			hash.Write(buf[:n])
+			size += n
+			cw.Write(buf[:n])
+			cw.Flush()
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	cw.Trailer().Set("X-Content-SHA256", toString(hash.Sum(nil)))
+	cw.Trailer().Set("X-Content-Length", fmt.Sprintf("%d", size))
+	return cw.Close()
+}
+
+// served from disk with Range support, so players can seek
+func handleVideo(w *response.Writer, req *request.Request) {
+	fileserver.ServeFile(w, req, "assets/vim.mp4")
+}
+
 func toString(data []byte) string {
 	out := ""
 	for _, d := range data {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"github.com/kalim-Asim/http-server/internal/request"
@@ -38,7 +39,12 @@ func main() {
 			fmt.Printf(" - %s: %s\n", key, val)
 		})
 
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			log.Fatal(err)
+		}
+
 		fmt.Println("Body:")
-		fmt.Printf("%s\n", req.Body)
+		fmt.Printf("%s\n", body)
 	}
 }
@@ -0,0 +1,379 @@
+// Package fileserver serves files from disk with the parts of HTTP that
+// make it usable for large files: conditional requests, byte ranges for
+// seeking and resuming, and content types from the name or the content.
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kalim-Asim/http-server/internal/headers"
+	"github.com/kalim-Asim/http-server/internal/request"
+	"github.com/kalim-Asim/http-server/internal/response"
+	"github.com/kalim-Asim/http-server/internal/server"
+)
+
+const indexPage = "index.html"
+
+// FileServer serves the files below a directory. Paths cannot leave it,
+// neither with ".." nor through symlinks pointing outside.
+// Its Serve method is a server.Handler.
+type FileServer struct {
+	root string
+
+	// router wildcard holding the file path, e.g. "path" for a route
+	// "/static/{path...}". Without it the whole request path is used.
+	Param string
+	// directories without an index.html get a generated listing,
+	// otherwise they are 404
+	Listing bool
+}
+
+func New(root string) *FileServer {
+	return &FileServer{root: root}
+}
+
+func (f *FileServer) Serve(w *response.Writer, req *request.Request) {
+	name, ok := f.name(req)
+	if !ok {
+		server.WriteError(w, req, server.Errorf(response.StatusNotFound, "Not Found"))
+		return
+	}
+
+	root, err := os.OpenRoot(f.root)
+	if err != nil {
+		server.WriteError(w, req, err)
+		return
+	}
+	defer root.Close()
+
+	file, err := root.Open(name)
+	if err != nil {
+		writeOpenError(w, req, err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		server.WriteError(w, req, err)
+		return
+	}
+
+	if info.IsDir() {
+		if !strings.HasSuffix(req.Path(), "/") {
+			// relative links in the page only work below a trailing slash
+			redirect(w, req, dirLocation(req))
+			return
+		}
+
+		index, err := root.Open(path.Join(name, indexPage))
+		if err == nil {
+			defer index.Close()
+			if info, err := index.Stat(); err == nil && !info.IsDir() {
+				serveContent(w, req, indexPage, info.ModTime(), info.Size(), index)
+				return
+			}
+		}
+
+		if !f.Listing {
+			server.WriteError(w, req, server.Errorf(response.StatusNotFound, "Not Found"))
+			return
+		}
+		f.list(w, req, file)
+		return
+	}
+
+	serveContent(w, req, info.Name(), info.ModTime(), info.Size(), file)
+}
+
+// ServeFile answers req with the file at name, a path chosen by the server,
+// not taken from the request. Directories are 404.
+func ServeFile(w *response.Writer, req *request.Request, name string) {
+	file, err := os.Open(name)
+	if err != nil {
+		writeOpenError(w, req, err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		server.WriteError(w, req, err)
+		return
+	}
+	if info.IsDir() {
+		server.WriteError(w, req, server.Errorf(response.StatusNotFound, "Not Found"))
+		return
+	}
+
+	serveContent(w, req, filepath.Base(name), info.ModTime(), info.Size(), file)
+}
+
+// the file path relative to the root, false for names trying to escape it
+func (f *FileServer) name(req *request.Request) (string, bool) {
+	name, ok := req.PathParams[f.Param]
+	if f.Param == "" || !ok {
+		var err error
+		name, err = url.PathUnescape(req.Path())
+		if err != nil {
+			return "", false
+		}
+	}
+
+	if strings.ContainsRune(name, 0) {
+		return "", false
+	}
+	for _, seg := range strings.Split(name, "/") {
+		if seg == ".." {
+			return "", false
+		}
+	}
+
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		name = "."
+	}
+	return name, true
+}
+
+// missing files are 404, unreadable ones 403, the rest a plain 500
+func writeOpenError(w *response.Writer, req *request.Request, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		server.WriteError(w, req, server.Errorf(response.StatusNotFound, "Not Found"))
+	case errors.Is(err, fs.ErrPermission):
+		server.WriteError(w, req, server.Errorf(response.StatusForbidden, "Forbidden"))
+	default:
+		// includes paths escaping the root through a symlink
+		server.WriteError(w, req, err)
+	}
+}
+
+// the request path with a trailing slash and the query kept. The path is
+// cleaned first: "//evil.com" taken as is would be a scheme-relative URL
+// sending the client off-site, and browsers read "\" as "/" there
+func dirLocation(req *request.Request) string {
+	location := path.Clean("/"+strings.ReplaceAll(req.Path(), `\`, "%5C")) + "/"
+	if location == "//" {
+		location = "/"
+	}
+	if _, query, ok := strings.Cut(req.RequestLine.RequestTarget, "?"); ok {
+		location += "?" + query
+	}
+	return location
+}
+
+func redirect(w *response.Writer, req *request.Request, location string) {
+	aw := response.NewAutoWriter(w)
+	aw.Header().Set("Location", location)
+	aw.WriteHeader(response.StatusMovedPermanently)
+	aw.Close()
+}
+
+// answers with the content of a file, or the part of it asked for,
+// unless the client's copy is still current
+func serveContent(w *response.Writer, req *request.Request, name string, modTime time.Time, size int64, content io.ReadSeeker) {
+	method := req.RequestLine.Method
+	if method != "GET" && method != "HEAD" {
+		w.AddHeader("Allow", "GET, HEAD")
+		server.WriteError(w, req, server.Errorf(response.StatusMethodNotAllowed, "Method Not Allowed"))
+		return
+	}
+
+	ctype, err := contentType(name, content)
+	if err != nil {
+		server.WriteError(w, req, err)
+		return
+	}
+
+	aw := response.NewAutoWriter(w)
+	h := aw.Header()
+	tag := etag(modTime, size)
+	h.Set("ETag", tag)
+	h.Set("Last-Modified", headers.FormatTime(modTime))
+	h.Set("Accept-Ranges", "bytes")
+
+	if notModified(req, tag, modTime) {
+		aw.WriteHeader(response.StatusNotModified)
+		aw.Close()
+		return
+	}
+
+	ranges, err := parseRange(req.Headers.Get("range"), size)
+	if !rangeCurrent(req, tag, modTime) {
+		// the client's copy is outdated, it needs the whole file
+		ranges, err = nil, nil
+	}
+	if err == errUnsatisfiable {
+		w.AddHeader("Content-Range", fmt.Sprintf("bytes */%d", size))
+		server.WriteError(w, req, server.Errorf(response.StatusRangeNotSatisfiable, "Range Not Satisfiable"))
+		return
+	}
+	// a malformed Range is ignored (RFC 9110 14.2)
+
+	var boundary string
+	switch {
+	case len(ranges) == 1:
+		h.Set("Content-Type", ctype)
+		h.Set("Content-Range", ranges[0].contentRange(size))
+		h.Set("Content-Length", strconv.FormatInt(ranges[0].length, 10))
+		aw.WriteHeader(response.StatusPartialContent)
+	case len(ranges) > 1:
+		boundary = randomBoundary()
+		h.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+		h.Set("Content-Length", strconv.FormatInt(multipartSize(ranges, ctype, size, boundary), 10))
+		aw.WriteHeader(response.StatusPartialContent)
+	default:
+		h.Set("Content-Type", ctype)
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+
+	if method == "HEAD" {
+		// the headers are all there is, no need to read the file
+		aw.Close()
+		return
+	}
+
+	switch {
+	case len(ranges) == 1:
+		if _, err := content.Seek(ranges[0].start, io.SeekStart); err != nil {
+			// the body falls short of its Content-Length,
+			// the server closes the connection after it
+			break
+		}
+		io.CopyN(aw, content, ranges[0].length)
+	case len(ranges) > 1:
+		writeMultipart(aw, content, ranges, ctype, size, boundary)
+	default:
+		io.CopyN(aw, content, size)
+	}
+
+	aw.Close()
+}
+
+// by extension first, sniffing the first 512 bytes otherwise
+func contentType(name string, content io.ReadSeeker) (string, error) {
+	if ctype := mime.TypeByExtension(filepath.Ext(name)); ctype != "" {
+		return ctype, nil
+	}
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(content, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// a strong validator from what changes whenever the file does
+func etag(modTime time.Time, size int64) string {
+	return fmt.Sprintf(`"%x-%x"`, modTime.UnixNano(), size)
+}
+
+// If-None-Match wins over If-Modified-Since (RFC 9110 13.2.2),
+// both compare weakly
+func notModified(req *request.Request, tag string, modTime time.Time) bool {
+	if inm := req.Headers.Get("if-none-match"); inm != "" {
+		return matchETag(inm, tag, false)
+	}
+
+	ims, err := req.Headers.Time("if-modified-since")
+	if err != nil {
+		return false
+	}
+	// Last-Modified only has second precision
+	return !modTime.Truncate(time.Second).After(ims)
+}
+
+// If-Range makes a Range conditional: the range applies only if the client
+// still has the current version, an outdated copy needs the whole file
+func rangeCurrent(req *request.Request, tag string, modTime time.Time) bool {
+	ir := req.Headers.Get("if-range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		return matchETag(ir, tag, true)
+	}
+	t, err := headers.ParseTime(ir)
+	return err == nil && modTime.Truncate(time.Second).Equal(t)
+}
+
+// reports whether the entity-tag list contains tag. "*" matches anything.
+// A strong comparison never matches weak tags.
+func matchETag(list, tag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for list != "" {
+		list = strings.TrimLeft(list, " \t,")
+		weak := strings.HasPrefix(list, "W/")
+		if weak {
+			list = list[2:]
+		}
+		if !strings.HasPrefix(list, `"`) {
+			return false
+		}
+		// entity tags may contain commas, the closing quote ends them
+		end := strings.IndexByte(list[1:], '"')
+		if end < 0 {
+			return false
+		}
+		candidate := list[:end+2]
+		list = list[end+2:]
+
+		if candidate == tag && !(strong && weak) {
+			return true
+		}
+	}
+	return false
+}
+
+// writes a minimal html index of a directory
+func (f *FileServer) list(w *response.Writer, req *request.Request, dir fs.File) {
+	rd, ok := dir.(fs.ReadDirFile)
+	if !ok {
+		server.WriteError(w, req, server.Errorf(response.StatusForbidden, "Forbidden"))
+		return
+	}
+	entries, err := rd.ReadDir(-1)
+	if err != nil {
+		server.WriteError(w, req, err)
+		return
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	title := html.EscapeString(req.Path())
+	aw := response.NewAutoWriter(w)
+	aw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(aw, "<html>\n<head><title>Index of %s</title></head>\n<body>\n<h1>Index of %s</h1>\n<ul>\n", title, title)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		href := (&url.URL{Path: name}).EscapedPath()
+		fmt.Fprintf(aw, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(name))
+	}
+	fmt.Fprintf(aw, "</ul>\n</body>\n</html>\n")
+	aw.Close()
+}
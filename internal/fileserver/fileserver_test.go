@@ -0,0 +1,286 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kalim-Asim/http-server/internal/request"
+	"github.com/kalim-Asim/http-server/internal/response"
+	"github.com/kalim-Asim/http-server/internal/router"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var modTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// a root with a few files and a secret next to it
+func testRoot(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	root := filepath.Join(dir, "public")
+
+	files := map[string]string{
+		"public/hello.txt":       "hello, world\n",
+		"public/noext":           "<!DOCTYPE html><html><body>sniffed</body></html>",
+		"public/docs/index.html": "<h1>docs</h1>",
+		"public/files/b.txt":     "b",
+		"public/files/a <&>.txt": "a",
+		"public/files/sub/c.txt": "c",
+		"secret.txt":             "top secret",
+		"public/digits.txt":      "0123456789",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+		require.NoError(t, os.Chtimes(p, modTime, modTime))
+	}
+	require.NoError(t, os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(root, "escape.txt")))
+	return root
+}
+
+// runs a raw request through h and parses the response
+func do(t *testing.T, h func(*response.Writer, *request.Request), raw string) (*http.Response, string) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	w.SetMethod(req.RequestLine.Method)
+	h(w, req)
+
+	res, err := http.ReadResponse(bufio.NewReader(&buf), &http.Request{Method: req.RequestLine.Method})
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, string(body)
+}
+
+func get(target string, fields ...string) string {
+	return "GET " + target + " HTTP/1.1\r\n" + strings.Join(fields, "\r\n") + "\r\n\r\n"
+}
+
+func TestServe(t *testing.T) {
+	fs := New(testRoot(t))
+
+	t.Run("File", func(t *testing.T) {
+		res, body := do(t, fs.Serve, get("/hello.txt"))
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, "hello, world\n", body)
+		assert.Equal(t, "text/plain; charset=utf-8", res.Header.Get("Content-Type"))
+		assert.Equal(t, int64(13), res.ContentLength)
+		assert.Equal(t, "Wed, 01 May 2024 12:00:00 GMT", res.Header.Get("Last-Modified"))
+		assert.Equal(t, "bytes", res.Header.Get("Accept-Ranges"))
+		assert.NotEmpty(t, res.Header.Get("ETag"))
+	})
+
+	t.Run("HEAD", func(t *testing.T) {
+		res, body := do(t, fs.Serve, "HEAD /hello.txt HTTP/1.1\r\n\r\n")
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Empty(t, body)
+		assert.Equal(t, int64(13), res.ContentLength)
+	})
+
+	t.Run("Sniffed content type", func(t *testing.T) {
+		res, _ := do(t, fs.Serve, get("/noext"))
+		assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+	})
+
+	t.Run("Traversal", func(t *testing.T) {
+		for _, target := range []string{"/../secret.txt", "/%2e%2e/secret.txt", "/docs/../../secret.txt", "/escape.txt"} {
+			res, body := do(t, fs.Serve, get(target))
+			assert.NotEqual(t, 200, res.StatusCode, target)
+			assert.NotContains(t, body, "top secret", target)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		res, _ := do(t, fs.Serve, get("/nope.txt"))
+		assert.Equal(t, 404, res.StatusCode)
+	})
+
+	t.Run("Directory", func(t *testing.T) {
+		res, _ := do(t, fs.Serve, get("/docs"))
+		assert.Equal(t, 301, res.StatusCode)
+		assert.Equal(t, "/docs/", res.Header.Get("Location"))
+
+		res, _ = do(t, fs.Serve, get("/docs?a=1&b"))
+		assert.Equal(t, 301, res.StatusCode)
+		assert.Equal(t, "/docs/?a=1&b", res.Header.Get("Location"))
+
+		// never a scheme-relative URL leading off-site
+		for _, target := range []string{"//docs", "///docs", "/./docs"} {
+			res, _ = do(t, fs.Serve, get(target))
+			require.Equal(t, 301, res.StatusCode, target)
+			location := res.Header.Get("Location")
+			assert.True(t, strings.HasPrefix(location, "/"), target)
+			assert.False(t, strings.HasPrefix(location, "//") || strings.HasPrefix(location, "/\\"), "%s: %s", target, location)
+		}
+
+		res, body := do(t, fs.Serve, get("/docs/"))
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, "<h1>docs</h1>", body)
+
+		res, _ = do(t, fs.Serve, get("/files/"))
+		assert.Equal(t, 404, res.StatusCode, "no listing unless enabled")
+	})
+
+	t.Run("Listing", func(t *testing.T) {
+		fs := New(fs.root)
+		fs.Listing = true
+		res, body := do(t, fs.Serve, get("/files/"))
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+		assert.Contains(t, body, `<li><a href="a%20%3C&amp;%3E.txt">a &lt;&amp;&gt;.txt</a></li>`)
+		assert.Contains(t, body, `<li><a href="sub/">sub/</a></li>`)
+		assert.Less(t, strings.Index(body, "a%20"), strings.Index(body, "b.txt"))
+	})
+
+	t.Run("Behind a router wildcard", func(t *testing.T) {
+		fs := New(fs.root)
+		fs.Param = "path"
+		r := router.New()
+		r.Get("/static/{path...}", fs.Serve)
+
+		_, body := do(t, r.Serve, get("/static/files/sub/c.txt"))
+		assert.Equal(t, "c", body)
+		res, _ := do(t, r.Serve, get("/static/%2E%2E/secret.txt"))
+		assert.Equal(t, 404, res.StatusCode)
+	})
+}
+
+func TestConditional(t *testing.T) {
+	fs := New(testRoot(t))
+	res, _ := do(t, fs.Serve, get("/hello.txt"))
+	tag := res.Header.Get("ETag")
+
+	cases := []struct {
+		name   string
+		field  string
+		status int
+	}{
+		{"Matching ETag", "If-None-Match: " + tag, 304},
+		{"Weak match", "If-None-Match: \"other\", W/" + tag, 304},
+		{"Any", "If-None-Match: *", 304},
+		{"Other ETag", "If-None-Match: \"other\"", 200},
+		{"Not modified since", "If-Modified-Since: Wed, 01 May 2024 12:00:00 GMT", 304},
+		{"Obsolete date format", "If-Modified-Since: Wednesday, 01-May-24 13:00:00 GMT", 304},
+		{"Modified since", "If-Modified-Since: Tue, 30 Apr 2024 12:00:00 GMT", 200},
+		{"Bad date", "If-Modified-Since: yesterday", 200},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, body := do(t, fs.Serve, get("/hello.txt", c.field))
+			assert.Equal(t, c.status, res.StatusCode)
+			if c.status == 304 {
+				assert.Empty(t, body)
+				assert.Equal(t, tag, res.Header.Get("ETag"))
+				assert.Empty(t, res.Header.Get("Content-Length"))
+			}
+		})
+	}
+
+	t.Run("If-None-Match wins over If-Modified-Since", func(t *testing.T) {
+		res, _ := do(t, fs.Serve, get("/hello.txt", "If-None-Match: \"other\"", "If-Modified-Since: Wed, 01 May 2024 12:00:00 GMT"))
+		assert.Equal(t, 200, res.StatusCode)
+	})
+}
+
+func TestRange(t *testing.T) {
+	fs := New(testRoot(t))
+
+	t.Run("Single", func(t *testing.T) {
+		for field, want := range map[string]string{
+			"Range: bytes=2-4":  "234",
+			"Range: bytes=7-":   "789",
+			"Range: bytes=-3":   "789",
+			"Range: bytes=8-99": "89",
+		} {
+			res, body := do(t, fs.Serve, get("/digits.txt", field))
+			assert.Equal(t, 206, res.StatusCode, field)
+			assert.Equal(t, want, body, field)
+			assert.Equal(t, int64(len(want)), res.ContentLength, field)
+		}
+
+		res, _ := do(t, fs.Serve, get("/digits.txt", "Range: bytes=2-4"))
+		assert.Equal(t, "bytes 2-4/10", res.Header.Get("Content-Range"))
+		assert.Equal(t, "text/plain; charset=utf-8", res.Header.Get("Content-Type"))
+	})
+
+	t.Run("Multipart", func(t *testing.T) {
+		res, body := do(t, fs.Serve, get("/digits.txt", "Range: bytes=0-1, 5-6"))
+		assert.Equal(t, 206, res.StatusCode)
+		assert.Equal(t, int64(len(body)), res.ContentLength)
+
+		mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+		require.NoError(t, err)
+		assert.Equal(t, "multipart/byteranges", mediaType)
+
+		mr := multipart.NewReader(strings.NewReader(body), params["boundary"])
+		for _, want := range []struct{ rng, data string }{{"bytes 0-1/10", "01"}, {"bytes 5-6/10", "56"}} {
+			part, err := mr.NextPart()
+			require.NoError(t, err)
+			assert.Equal(t, want.rng, part.Header.Get("Content-Range"))
+			assert.Equal(t, "text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+			data, _ := io.ReadAll(part)
+			assert.Equal(t, want.data, string(data))
+		}
+		_, err = mr.NextPart()
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("Unsatisfiable", func(t *testing.T) {
+		res, _ := do(t, fs.Serve, get("/digits.txt", "Range: bytes=10-"))
+		assert.Equal(t, 416, res.StatusCode)
+		assert.Equal(t, "bytes */10", res.Header.Get("Content-Range"))
+	})
+
+	t.Run("Malformed or excessive ranges are ignored", func(t *testing.T) {
+		for _, field := range []string{"Range: bytes=5-2", "Range: lines=1-2", "Range: bytes=x-", "Range: bytes=0-, 0-, 0-"} {
+			res, body := do(t, fs.Serve, get("/digits.txt", field))
+			assert.Equal(t, 200, res.StatusCode, field)
+			assert.Equal(t, "0123456789", body, field)
+		}
+	})
+
+	t.Run("If-Range", func(t *testing.T) {
+		res, _ := do(t, fs.Serve, get("/digits.txt"))
+		tag := res.Header.Get("ETag")
+
+		res, body := do(t, fs.Serve, get("/digits.txt", "Range: bytes=0-0", "If-Range: "+tag))
+		assert.Equal(t, 206, res.StatusCode)
+		assert.Equal(t, "0", body)
+
+		res, _ = do(t, fs.Serve, get("/digits.txt", "Range: bytes=0-0", "If-Range: Wed, 01 May 2024 12:00:00 GMT"))
+		assert.Equal(t, 206, res.StatusCode)
+
+		res, body = do(t, fs.Serve, get("/digits.txt", "Range: bytes=0-0", "If-Range: \"stale\""))
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, "0123456789", body)
+	})
+}
+
+func TestServeFile(t *testing.T) {
+	root := testRoot(t)
+
+	res, body := do(t, func(w *response.Writer, req *request.Request) {
+		ServeFile(w, req, filepath.Join(root, "digits.txt"))
+	}, get("/video", "Range: bytes=-2"))
+	assert.Equal(t, 206, res.StatusCode)
+	assert.Equal(t, "89", body)
+
+	res, _ = do(t, func(w *response.Writer, req *request.Request) {
+		ServeFile(w, req, root)
+	}, get("/"))
+	assert.Equal(t, 404, res.StatusCode)
+}
@@ -0,0 +1,156 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// more ranges than this in one request are treated as abuse
+const maxRanges = 100
+
+var errMalformedRange = errors.New("malformed range")
+var errUnsatisfiable = errors.New("no satisfiable range")
+
+// a byte range resolved against the file size
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parses a Range header (RFC 9110 14.1.2): "bytes=0-499", "bytes=500-",
+// "bytes=-500", comma separated. Ranges beyond the end of the file are
+// dropped, errUnsatisfiable if none is left. No header gives no ranges.
+// Sets of ranges that add up to more than the file are ignored, serving
+// the whole file is cheaper than serving it several times over.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	if header == "" {
+		return nil, nil
+	}
+	unit, set, ok := strings.Cut(header, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, errMalformedRange
+	}
+
+	specs := strings.Split(set, ",")
+	if len(specs) > maxRanges {
+		return nil, errMalformedRange
+	}
+
+	ranges := []byteRange{}
+	var total int64
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, errMalformedRange
+		}
+
+		var r byteRange
+		if first == "" {
+			// suffix range, the last n bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errMalformedRange
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			r = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errMalformedRange
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, errMalformedRange
+				}
+				end = min(end, size-1)
+			}
+			if start >= size {
+				continue
+			}
+			r = byteRange{start: start, length: end - start + 1}
+		}
+
+		ranges = append(ranges, r)
+		total += r.length
+	}
+
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiable
+	}
+	if total > size {
+		return nil, nil
+	}
+	return ranges, nil
+}
+
+func randomBoundary() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func partHeader(r byteRange, ctype string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Type":  {ctype},
+		"Content-Range": {r.contentRange(size)},
+	}
+}
+
+// the exact length of the multipart body writeMultipart produces,
+// so the response can carry a Content-Length
+func multipartSize(ranges []byteRange, ctype string, size int64, boundary string) int64 {
+	var c countingWriter
+	mw := multipart.NewWriter(&c)
+	mw.SetBoundary(boundary)
+	for _, r := range ranges {
+		mw.CreatePart(partHeader(r, ctype, size))
+		c += countingWriter(r.length)
+	}
+	mw.Close()
+	return int64(c)
+}
+
+// writes the ranges as a multipart/byteranges body (RFC 9110 14.6)
+func writeMultipart(w io.Writer, content io.ReadSeeker, ranges []byteRange, ctype string, size int64, boundary string) error {
+	mw := multipart.NewWriter(w)
+	mw.SetBoundary(boundary)
+	for _, r := range ranges {
+		part, err := mw.CreatePart(partHeader(r, ctype, size))
+		if err != nil {
+			return err
+		}
+		if _, err := content.Seek(r.start, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(part, content, r.length); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
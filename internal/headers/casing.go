@@ -0,0 +1,79 @@
+package headers
+
+import (
+	"fmt"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// Casing decides how field names are written on the wire. Names are
+// case-insensitive (RFC 9110 5.1), but not every client treats them so.
+type Casing int
+
+const (
+	// "content-type" is sent as "Content-Type", names the rule gets
+	// wrong have an exact spelling, see AddExactName
+	CaseCanonical Casing = iota
+	// names are sent as they were set or received
+	CasePreserve
+	// "Content-Type" is sent as "content-type"
+	CaseLower
+)
+
+func (c Casing) String() string {
+	switch c {
+	case CaseCanonical:
+		return "canonical"
+	case CasePreserve:
+		return "preserve"
+	case CaseLower:
+		return "lower"
+	}
+	return fmt.Sprintf("Casing(%d)", int(c))
+}
+
+// Format returns key the way c writes it
+func (c Casing) Format(key string) string {
+	switch c {
+	case CasePreserve:
+		return key
+	case CaseLower:
+		return strings.ToLower(key)
+	}
+	return CanonicalName(key)
+}
+
+var (
+	exactMu sync.RWMutex
+	// lower case name -> spelling, for names canonical casing gets wrong
+	exactNames = map[string]string{
+		"content-md5":      "Content-MD5",
+		"dnt":              "DNT",
+		"etag":             "ETag",
+		"te":               "TE",
+		"www-authenticate": "WWW-Authenticate",
+		"x-xss-protection": "X-XSS-Protection",
+	}
+)
+
+// CanonicalName returns the canonical spelling of a field name: the
+// registered exact spelling if there is one, otherwise the first letter
+// and every letter after a hyphen upper case and the rest lower case.
+func CanonicalName(key string) string {
+	exactMu.RLock()
+	name, ok := exactNames[strings.ToLower(key)]
+	exactMu.RUnlock()
+	if ok {
+		return name
+	}
+	return textproto.CanonicalMIMEHeaderKey(key)
+}
+
+// AddExactName makes CaseCanonical write name exactly as given,
+// e.g. AddExactName("X-API-Key")
+func AddExactName(name string) {
+	exactMu.Lock()
+	defer exactMu.Unlock()
+	exactNames[strings.ToLower(name)] = name
+}
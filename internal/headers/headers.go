@@ -3,6 +3,7 @@ package headers
 import (
 	"bytes"
 	"fmt"
+	"slices"
 	"strings"
 )
 
@@ -11,16 +12,36 @@ import (
 field-line   = field-name ":" OWS field-value OWS
 */
 
+// Error is a header parse error together with
+// the response status it implies
+type Error struct {
+	StatusCode int
+	Reason     string
+}
+
+func (e *Error) Error() string {
+	return e.Reason
+}
+
 var (
 	SEPARATOR = []byte("\r\n") // cflf token
-	ERROR_BAD_FIELD_NAME = fmt.Errorf("malformed field name")
-	ERROR_CRLF_NOT_FOUND = fmt.Errorf("crlf token not found")
-	ERROR_BAD_HEADER = fmt.Errorf("header does not match")
-	ERROR_INVALID_FIELD_NAME = fmt.Errorf("field name is invalid")
+	ERROR_BAD_FIELD_NAME = &Error{400, "malformed field name"}
+	ERROR_CRLF_NOT_FOUND = &Error{400, "crlf token not found"}
+	ERROR_BAD_HEADER = &Error{400, "header does not match"}
+	ERROR_INVALID_FIELD_NAME = &Error{400, "field name is invalid"}
+	ERROR_INVALID_FIELD_VALUE = &Error{400, "field value is invalid"}
+	ERROR_OBS_FOLD = &Error{400, "obsolete line folding"}
 )
 
 // returns key, value, error 
 func parseHeader(fieldLine []byte) (string, string, error) {
+	// obs-fold (RFC 9112 5.2): a proxy may read the line as part of the
+	// field before it, taking it for a field of its own would let the
+	// two disagree on the framing
+	if len(fieldLine) > 0 && (fieldLine[0] == ' ' || fieldLine[0] == '\t') {
+		return "", "", ERROR_OBS_FOLD
+	}
+
 	parts := bytes.SplitN(fieldLine, []byte(":"), 2)
 	if len(parts) != 2 {
 		return "", "", ERROR_BAD_FIELD_NAME
@@ -38,45 +59,131 @@ func parseHeader(fieldLine []byte) (string, string, error) {
 }
 
 // example: header -> Host: localhost:42069\r\n (valid)
+//
+// Fields keep the order they were added in and the name as it was given,
+// lookups ignore case (RFC 9110 5.1). A name can appear more than once,
+// e.g. Set-Cookie, which cannot be combined into one line (RFC 6265 3).
 type Headers struct {
-	// key lower case
-	// value can be multiple as per RFC 9110 5.2
-	headers map[string]string
+	fields []field
+}
+
+type field struct {
+	name  string
+	value string
 }
 
 func NewHeaders() *Headers {
-	return &Headers{
-		headers: make(map[string]string),
-	}
+	return &Headers{}
 }
 
+// Get returns the values of a field combined into one, separated by
+// ", " (RFC 9110 5.3), "" if there is none
 func (h *Headers) Get(key string) string {
-	return h.headers[strings.ToLower(key)]
+	return strings.Join(h.Values(key), ", ")
+}
+
+// Values returns every value of a field in order
+func (h *Headers) Values(key string) []string {
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.name, key) {
+			values = append(values, f.value)
+		}
+	}
+	return values
 }
 
 func (h* Headers) Has(key string) bool {
-	_, ok := h.headers[strings.ToLower(key)]
-	return ok 
+	for _, f := range h.fields {
+		if strings.EqualFold(f.name, key) {
+			return true
+		}
+	}
+	return false
 }
 
 func (h* Headers) Delete(key string) {
-	key = strings.ToLower(key)
-	delete(h.headers, key)
-} 
+	h.fields = slices.DeleteFunc(h.fields, func(f field) bool {
+		return strings.EqualFold(f.name, key)
+	})
+}
 
+// Set replaces all values of a field. It keeps the place of the first one.
+// An invalid name or value is dropped, see SetChecked for why.
 func (h *Headers) Set(key, value string) {
-	h.headers[strings.ToLower(key)] = value 
+	if checkField(key, value) != nil {
+		return
+	}
+	i := slices.IndexFunc(h.fields, func(f field) bool {
+		return strings.EqualFold(f.name, key)
+	})
+	if i < 0 {
+		h.Add(key, value)
+		return
+	}
+	h.fields[i] = field{key, value}
+	rest := slices.DeleteFunc(h.fields[i+1:], func(f field) bool {
+		return strings.EqualFold(f.name, key)
+	})
+	h.fields = h.fields[:i+1+len(rest)]
+}
+
+// SetChecked is Set reporting an invalid field instead of dropping it
+// silently. An invalid field is never stored, it would let the value end
+// the field line early and add lines of its own.
+func (h *Headers) SetChecked(key, value string) error {
+	if err := checkField(key, value); err != nil {
+		return err
+	}
+	h.Set(key, value)
+	return nil
+}
+
+// Validate returns an error for the first field that cannot be sent as is
+func (h *Headers) Validate() error {
+	for _, f := range h.fields {
+		if err := checkField(f.name, f.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkField(key, value string) error {
+	if !isToken(key) {
+		return fmt.Errorf("%w: %q", ERROR_INVALID_FIELD_NAME, key)
+	}
+	if !isFieldValue(value) {
+		return fmt.Errorf("%w: %s: %q", ERROR_INVALID_FIELD_VALUE, key, value)
+	}
+	return nil
+}
+
+// Add appends a value, after any the field already has
+func (h *Headers) Add(key, value string) {
+	h.fields = append(h.fields, field{key, value})
+}
+
+// Len returns the number of field lines
+func (h *Headers) Len() int {
+	return len(h.fields)
+}
+
+// Clone returns a copy that can be changed independently
+func (h *Headers) Clone() *Headers {
+	return &Headers{fields: slices.Clone(h.fields)}
 }
 
 func (h* Headers) PrintHeaders() {
-	for key, val := range h.headers {
-		fmt.Printf(" - %s: %s\n", key, val)
+	for _, f := range h.fields {
+		fmt.Printf(" - %s: %s\n", f.name, f.value)
 	}
 }
 
+// ForEach calls fn for every field line in order, with the name as given
 func (h Headers) ForEach(fn func(key, val string)) {
-	for k, v := range h.headers {
-		fn(k, v)
+	for _, f := range h.fields {
+		fn(f.name, f.value)
 	}
 }
 
@@ -111,14 +218,11 @@ func (h* Headers) Parse(data []byte) (int, bool, error) {
 		if !isToken(key) {
 			return 0, false, ERROR_INVALID_FIELD_NAME
 		}
-
-		if h.Has(key) {
-			oldValue := h.Get(key)
-			newValue := oldValue + ", " + val 
-			h.Set(key, newValue)
-		} else {
-			h.Set(key, val)
+		if !isFieldValue(val) {
+			return 0, false, ERROR_INVALID_FIELD_VALUE
 		}
+
+		h.Add(key, val)
 		
 		read += idx + len(SEPARATOR)
 	}
@@ -145,4 +249,16 @@ func isToken(str string) bool {
 		}
 	}
 	return len(str) >= 1 
-}
\ No newline at end of file
+}
+
+// field-value = *field-content (RFC 9110 5.5): visible characters, obs-text
+// (bytes 0x80-0xFF) and the spaces and tabs between them. Never CR, LF or NUL.
+func isFieldValue(str string) bool {
+	for i := 0; i < len(str); i++ {
+		ch := str[i]
+		if ch < ' ' && ch != '\t' || ch == 0x7f {
+			return false
+		}
+	}
+	return true
+}
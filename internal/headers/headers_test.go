@@ -2,6 +2,8 @@ package headers
 
 import (
 	"testing"
+	"time"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -23,16 +25,31 @@ func TestHeadersParse(t *testing.T) {
 
 	t.Run("Valid single header with extra whitespace", func(t *testing.T) {
 		headers := NewHeaders()
-		data := []byte("     Host:    localhost:42069     \r\n\r\n")
+		data := []byte("Host:    localhost:42069     \r\n\r\n")
 
 		n, done, err := headers.Parse(data)
 
 		require.NoError(t, err)
 		assert.Equal(t, "localhost:42069", headers.Get("Host"))
-		assert.Equal(t, 36, n) // includes leading + trailing whitespace + only first crlf
+		assert.Equal(t, 31, n) // includes trailing whitespace + only first crlf
 		assert.False(t, done)
 	})
 
+	t.Run("Invalid leading whitespace (obs-fold)", func(t *testing.T) {
+		for _, data := range []string{
+			"     Host: localhost:42069\r\n\r\n",
+			"Host: a\r\n Content-Length: 5\r\n\r\n",
+			"Host: a\r\n\tb\r\n\r\n",
+		} {
+			headers := NewHeaders()
+			n, done, err := headers.Parse([]byte(data))
+			assert.ErrorIs(t, err, ERROR_OBS_FOLD, "%q", data)
+			assert.Equal(t, 0, n)
+			assert.False(t, done)
+			assert.False(t, headers.Has("Content-Length"))
+		}
+	})
+
 	t.Run("Valid 2 headers with existing headers", func(t *testing.T) {
 		headers := NewHeaders()
 
@@ -64,7 +81,7 @@ func TestHeadersParse(t *testing.T) {
 		require.NoError(t, err)
 		assert.True(t, done)
 		assert.Equal(t, len(SEPARATOR), n)
-		assert.Len(t, headers.headers, 0)
+		assert.Equal(t, 0, headers.Len())
 	})
 
 	t.Run("Invalid spacing header", func(t *testing.T) {
@@ -123,3 +140,232 @@ func TestHeadersParse(t *testing.T) {
 		assert.Equal(t, 23, n)
 	})
 }
+
+func TestHeaders(t *testing.T) {
+	fields := func(h *Headers) []string {
+		var lines []string
+		h.ForEach(func(key, val string) {
+			lines = append(lines, key+": "+val)
+		})
+		return lines
+	}
+
+	t.Run("Order and case are kept", func(t *testing.T) {
+		h := NewHeaders()
+		_, _, err := h.Parse([]byte("Set-Cookie: a=1\r\nHost: example.com\r\nset-cookie: b=2\r\n\r\n"))
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"Set-Cookie: a=1", "Host: example.com", "set-cookie: b=2"}, fields(h))
+		assert.Equal(t, []string{"a=1", "b=2"}, h.Values("SET-COOKIE"))
+		assert.Equal(t, "a=1, b=2", h.Get("Set-Cookie"))
+		assert.Equal(t, 3, h.Len())
+	})
+
+	t.Run("Set replaces every value in place", func(t *testing.T) {
+		h := NewHeaders()
+		h.Add("Accept", "text/html")
+		h.Add("Vary", "Accept")
+		h.Add("accept", "*/*")
+		h.Set("ACCEPT", "application/json")
+
+		assert.Equal(t, []string{"ACCEPT: application/json", "Vary: Accept"}, fields(h))
+		assert.Nil(t, h.Values("Missing"))
+		assert.Equal(t, "", h.Get("Missing"))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		h := NewHeaders()
+		h.Add("X-A", "1")
+		h.Add("X-B", "2")
+		h.Add("x-a", "3")
+		h.Delete("X-A")
+
+		assert.False(t, h.Has("x-a"))
+		assert.Equal(t, []string{"X-B: 2"}, fields(h))
+	})
+
+	t.Run("Clone", func(t *testing.T) {
+		h := NewHeaders()
+		h.Add("X-A", "1")
+		c := h.Clone()
+		c.Set("X-A", "2")
+		c.Add("X-B", "3")
+
+		assert.Equal(t, "1", h.Get("X-A"))
+		assert.Equal(t, 1, h.Len())
+		assert.Equal(t, []string{"X-A: 2", "X-B: 3"}, fields(c))
+	})
+
+	t.Run("Zero value", func(t *testing.T) {
+		var h Headers
+		assert.False(t, h.Has("Host"))
+		h.Set("Host", "example.com")
+		assert.Equal(t, "example.com", h.Get("host"))
+	})
+}
+
+func TestCasing(t *testing.T) {
+	cases := []struct {
+		key                         string
+		canonical, preserve, lower string
+	}{
+		{"content-type", "Content-Type", "content-type", "content-type"},
+		{"X-REQUEST-ID", "X-Request-Id", "X-REQUEST-ID", "x-request-id"},
+		{"www-authenticate", "WWW-Authenticate", "www-authenticate", "www-authenticate"},
+		{"Etag", "ETag", "Etag", "etag"},
+		{"te", "TE", "te", "te"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.canonical, CaseCanonical.Format(c.key), c.key)
+		assert.Equal(t, c.preserve, CasePreserve.Format(c.key), c.key)
+		assert.Equal(t, c.lower, CaseLower.Format(c.key), c.key)
+	}
+
+	assert.Equal(t, "X-Api-Key", CanonicalName("x-api-key"))
+	AddExactName("X-API-Key")
+	assert.Equal(t, "X-API-Key", CanonicalName("x-api-key"))
+	assert.Equal(t, "x-api-key", CaseLower.Format("X-API-Key"))
+}
+
+func TestFieldValues(t *testing.T) {
+	t.Run("Parse", func(t *testing.T) {
+		for _, data := range []string{
+			"X-A: a\x00b\r\n\r\n",
+			"X-A: a\rb\r\n\r\n",
+			"X-A: a\nb\r\n\r\n",
+			"X-A: \x7f\r\n\r\n",
+		} {
+			h := NewHeaders()
+			_, _, err := h.Parse([]byte(data))
+			assert.ErrorIs(t, err, ERROR_INVALID_FIELD_VALUE, "%q", data)
+		}
+
+		h := NewHeaders()
+		_, _, err := h.Parse([]byte("X-A: tab\there, caf\xc3\xa9 \"q\"\r\n\r\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "tab\there, caf\xc3\xa9 \"q\"", h.Get("X-A"))
+	})
+
+	t.Run("SetChecked", func(t *testing.T) {
+		h := NewHeaders()
+		err := h.SetChecked("Location", "/a\r\nSet-Cookie: admin=1")
+		assert.ErrorIs(t, err, ERROR_INVALID_FIELD_VALUE)
+		assert.False(t, h.Has("Location"))
+
+		assert.ErrorIs(t, h.SetChecked("Bad Name", "x"), ERROR_INVALID_FIELD_NAME)
+		assert.ErrorIs(t, h.SetChecked("", "x"), ERROR_INVALID_FIELD_NAME)
+
+		require.NoError(t, h.SetChecked("Location", "/a b"))
+		assert.Equal(t, "/a b", h.Get("location"))
+
+		// Set drops it without telling
+		h.Set("Location", "/b\r\nSet-Cookie: admin=1")
+		h.Set("Bad Name", "x")
+		assert.Equal(t, "/a b", h.Get("location"))
+		assert.Equal(t, 1, h.Len())
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		h := NewHeaders()
+		h.Set("X-Ok", "1")
+		require.NoError(t, h.Validate())
+		h.Add("X-Bad", "1\r\n")
+		assert.ErrorIs(t, h.Validate(), ERROR_INVALID_FIELD_VALUE)
+	})
+}
+
+func TestTyped(t *testing.T) {
+	t.Run("Int", func(t *testing.T) {
+		h := NewHeaders()
+		_, err := h.Int("Content-Length")
+		assert.ErrorIs(t, err, ERROR_MISSING_FIELD)
+
+		h.Add("Content-Length", "42")
+		n, err := h.Int("content-length")
+		require.NoError(t, err)
+		assert.Equal(t, int64(42), n)
+
+		h.Add("Content-Length", "42, 42")
+		n, err = h.Int("content-length")
+		require.NoError(t, err)
+		assert.Equal(t, int64(42), n)
+
+		h.Add("Content-Length", "43")
+		_, err = h.Int("content-length")
+		assert.ErrorIs(t, err, ERROR_CONFLICTING_VALUES)
+
+		for _, v := range []string{"", "+5", "-1", "0x10", "1 2", "5,", "99999999999999999999"} {
+			h := NewHeaders()
+			h.Set("X-N", v)
+			_, err := h.Int("X-N")
+			assert.ErrorIs(t, err, ERROR_BAD_NUMBER, "%q", v)
+		}
+	})
+
+	t.Run("Time", func(t *testing.T) {
+		want := time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC)
+		for _, v := range []string{
+			"Sun, 06 Nov 1994 08:49:37 GMT",
+			"Sunday, 06-Nov-94 08:49:37 GMT",
+			"Sun Nov  6 08:49:37 1994",
+		} {
+			got, err := ParseTime(v)
+			require.NoError(t, err, v)
+			assert.True(t, want.Equal(got), v)
+		}
+		_, err := ParseTime("yesterday")
+		assert.ErrorIs(t, err, ERROR_BAD_DATE)
+
+		assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", FormatTime(want.In(time.FixedZone("X", 3600))))
+
+		h := NewHeaders()
+		h.Set("Date", FormatTime(want))
+		got, err := h.Time("date")
+		require.NoError(t, err)
+		assert.True(t, want.Equal(got))
+		h.Add("Date", "Mon, 07 Nov 1994 08:49:37 GMT")
+		_, err = h.Time("date")
+		assert.ErrorIs(t, err, ERROR_CONFLICTING_VALUES)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		assert.Equal(t, []string{"a", `"b, c"`, "d"}, ParseList(`a, "b, c", , d`))
+		assert.Equal(t, []string{`"x\", y"`, "z"}, ParseList(`"x\", y", z`))
+		assert.Nil(t, ParseList(" , "))
+
+		h := NewHeaders()
+		assert.Nil(t, h.List("Connection"))
+		h.Add("Connection", "keep-alive")
+		h.Add("Connection", "Upgrade, Close")
+		assert.Equal(t, []string{"keep-alive", "Upgrade", "Close"}, h.List("connection"))
+		assert.True(t, h.HasToken("connection", "close"))
+		assert.False(t, h.HasToken("connection", "clo"))
+	})
+
+	t.Run("Element", func(t *testing.T) {
+		e := ParseElement(`attachment; FileName="a;b \"c\".txt" ; size=10`)
+		assert.Equal(t, "attachment", e.Value)
+		assert.Equal(t, map[string]string{"filename": `a;b "c".txt`, "size": "10"}, e.Params)
+
+		e = ParseElement("text/html")
+		assert.Nil(t, e.Params)
+		assert.Equal(t, 1.0, e.Q())
+
+		h := NewHeaders()
+		h.Set("Accept", "text/html;level=1;q=0.5, */*;Q=0")
+		es := h.Elements("accept")
+		require.Len(t, es, 2)
+		assert.Equal(t, "text/html", es[0].Value)
+		assert.Equal(t, "1", es[0].Params["level"])
+		assert.Equal(t, 0.5, es[0].Q())
+		assert.Equal(t, 0.0, es[1].Q())
+
+		for q, want := range map[string]float64{
+			"1": 1, "1.000": 1, "0.001": 0.001, "0": 0, "0.": 0,
+			// malformed, ignored
+			"1.5": 1, "0.0001": 1, "2": 1, "-0.5": 1, "": 1, "abc": 1,
+		} {
+			assert.Equal(t, want, ParseElement("x;q="+q).Q(), "q=%s", q)
+		}
+	})
+}
@@ -0,0 +1,241 @@
+package headers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// typed access to field values (RFC 9110 5.6), so callers do not
+// split and convert strings by hand
+
+var (
+	ERROR_MISSING_FIELD      = &Error{400, "field is missing"}
+	ERROR_BAD_NUMBER         = &Error{400, "field value is not a number"}
+	ERROR_BAD_DATE           = &Error{400, "field value is not an HTTP-date"}
+	ERROR_CONFLICTING_VALUES = &Error{400, "conflicting field values"}
+)
+
+// TimeFormat is the IMF-fixdate format, the one HTTP-dates are sent in
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// IMF-fixdate and the two obsolete formats recipients still have to
+// accept (RFC 9110 5.6.7): RFC 850 and ANSI C asctime
+var timeFormats = []string{
+	TimeFormat,
+	"Monday, 02-Jan-06 15:04:05 GMT",
+	"Mon Jan _2 15:04:05 2006",
+}
+
+// Int returns a field holding a decimal number. The same number sent more
+// than once, in several lines or as a list, counts once (RFC 9110 8.6),
+// different ones are ERROR_CONFLICTING_VALUES.
+func (h *Headers) Int(key string) (int64, error) {
+	values := h.Values(key)
+	if len(values) == 0 {
+		return 0, ERROR_MISSING_FIELD
+	}
+
+	n := int64(-1)
+	for _, value := range values {
+		// every element has to be a number, empty ones included
+		for _, v := range strings.Split(value, ",") {
+			m, err := ParseInt(strings.TrimSpace(v))
+			if err != nil {
+				return 0, err
+			}
+			if n != -1 && m != n {
+				return 0, ERROR_CONFLICTING_VALUES
+			}
+			n = m
+		}
+	}
+	return n, nil
+}
+
+// ParseInt parses 1*DIGIT. Unlike strconv.ParseInt it takes no sign,
+// "+5" is not a number here.
+func ParseInt(s string) (int64, error) {
+	if s == "" {
+		return 0, ERROR_BAD_NUMBER
+	}
+	for _, ch := range s {
+		if ch < '0' || ch > '9' {
+			return 0, ERROR_BAD_NUMBER
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		// overflow
+		return 0, ERROR_BAD_NUMBER
+	}
+	return n, nil
+}
+
+// Time returns a field holding an HTTP-date. Dates contain commas, so
+// several lines are only accepted when they carry the same date.
+func (h *Headers) Time(key string) (time.Time, error) {
+	values := h.Values(key)
+	if len(values) == 0 {
+		return time.Time{}, ERROR_MISSING_FIELD
+	}
+	for _, v := range values[1:] {
+		if v != values[0] {
+			return time.Time{}, ERROR_CONFLICTING_VALUES
+		}
+	}
+	return ParseTime(values[0])
+}
+
+// ParseTime parses an HTTP-date in any of its three formats, the result is UTC
+func ParseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timeFormats {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, ERROR_BAD_DATE
+}
+
+// FormatTime formats t as an IMF-fixdate, always in GMT
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+// List returns the elements of a comma separated field over all of its
+// lines (RFC 9110 5.6.1), nil without the field
+func (h *Headers) List(key string) []string {
+	var list []string
+	for _, value := range h.Values(key) {
+		list = append(list, ParseList(value)...)
+	}
+	return list
+}
+
+// ParseList splits a list at the commas outside quoted strings and trims
+// the elements, empty ones are dropped: `a, "b, c", , d` is
+// [a, "b, c", d]. Quoted strings are kept as they are.
+func ParseList(value string) []string {
+	var list []string
+	for _, e := range split(value, ',') {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// HasToken reports whether a list field contains token, ignoring case,
+// e.g. "close" in "Connection: keep-alive, Close"
+func (h *Headers) HasToken(key, token string) bool {
+	for _, e := range h.List(key) {
+		if strings.EqualFold(e, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Element is a list element with parameters,
+// e.g. `text/html;level=1;q=0.5` or `attachment; filename="a;b.txt"`
+type Element struct {
+	// before the first ";", trimmed
+	Value string
+	// names in lower case, quoted values unquoted; nil without parameters
+	Params map[string]string
+}
+
+// Elements returns the list elements of a field with their parameters
+func (h *Headers) Elements(key string) []Element {
+	var elements []Element
+	for _, e := range h.List(key) {
+		elements = append(elements, ParseElement(e))
+	}
+	return elements
+}
+
+// ParseElement splits `value *( OWS ";" OWS name=value )` (RFC 9110 5.6.6).
+// Parameters without "=" get an empty value, the first of a repeated
+// name counts.
+func ParseElement(s string) Element {
+	parts := split(s, ';')
+	e := Element{Value: strings.TrimSpace(parts[0])}
+	for _, p := range parts[1:] {
+		name, value, _ := strings.Cut(p, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if e.Params == nil {
+			e.Params = map[string]string{}
+		}
+		if _, ok := e.Params[name]; !ok {
+			e.Params[name] = unquote(strings.TrimSpace(value))
+		}
+	}
+	return e
+}
+
+// Q returns the weight in the "q" parameter (RFC 9110 12.4.2): 1 when it
+// is missing, and when it is malformed, then the parameter is ignored
+func (e Element) Q() float64 {
+	q, ok := e.Params["q"]
+	if !ok || !isQValue(q) {
+		return 1
+	}
+	v, _ := strconv.ParseFloat(q, 64)
+	return v
+}
+
+// qvalue = ( "0" [ "." 0*3DIGIT ] ) / ( "1" [ "." 0*3("0") ] )
+func isQValue(s string) bool {
+	whole, frac, dot := strings.Cut(s, ".")
+	if whole != "0" && whole != "1" || dot && len(frac) > 3 {
+		return false
+	}
+	for _, ch := range frac {
+		if ch < '0' || ch > '9' || whole == "1" && ch != '0' {
+			return false
+		}
+	}
+	return true
+}
+
+// splits s at sep outside of quoted strings
+func split(s string, sep byte) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quoted && s[i] == '\\':
+			i++ // quoted-pair, the next byte is literal
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// the content of a quoted-string with its quoted-pairs resolved,
+// anything else as it is
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	s = s[1 : len(s)-1]
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
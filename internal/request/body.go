@@ -0,0 +1,180 @@
+package request
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+)
+
+// NoBody is the Body of requests that carry no message body
+var NoBody = noBody{}
+
+type noBody struct{}
+
+func (noBody) Read([]byte) (int, error) { return 0, io.EOF }
+func (noBody) Close() error             { return nil }
+
+// body streams a message body off the connection,
+// framed either by Content-Length or by chunked transfer coding
+type body struct {
+	src     *Reader
+	req     *Request // receives the trailers of a chunked body
+	chunked bool
+
+	// bytes left of the content-length body or of the current chunk
+	remaining int64
+	// decoded bytes so far, checked against Limits.MaxBodyBytes
+	total int64
+	// a chunk has been read, its CRLF still has to be consumed
+	inChunk bool
+	sawEOF  bool
+	closed  bool
+	err     error // sticky, returned by every read after a failure
+}
+
+func newBody(src *Reader, req *Request) *body {
+	b := &body{
+		src: src,
+		req: req,
+	}
+	if req.chunked {
+		b.chunked = true
+	} else {
+		b.remaining = req.ContentLength
+	}
+	return b
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ERROR_BODY_CLOSED
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.sawEOF {
+		return 0, io.EOF
+	}
+
+	n, err := b.read(p)
+	if err != nil {
+		if err == io.EOF {
+			b.sawEOF = true
+		} else {
+			b.err = err
+		}
+	}
+	return n, err
+}
+
+func (b *body) read(p []byte) (int, error) {
+	if b.chunked && b.remaining == 0 {
+		if err := b.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	if b.remaining == 0 {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	n, err := b.src.read(p[:min(int64(len(p)), b.remaining)])
+	b.remaining -= int64(n)
+	b.total += int64(n)
+	if n == 0 && err == io.EOF {
+		// connection closed before the whole body arrived
+		return 0, ERROR_UNEXPECTED_EOF
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// moves past the end of the current chunk and reads the size of the
+// next one. On the last-chunk it reads the trailers and returns io.EOF
+func (b *body) nextChunk() error {
+	if b.inChunk {
+		line, err := b.src.readLine()
+		if err != nil {
+			return err
+		}
+		if len(line) != 0 {
+			return ERROR_BAD_CHUNK
+		}
+		b.inChunk = false
+	}
+
+	line, err := b.src.readLine()
+	if err != nil {
+		return err
+	}
+	size, err := parseChunkSize(line)
+	if err != nil {
+		return err
+	}
+
+	// last-chunk, only trailers left
+	if size == 0 {
+		if err := b.src.readFields(b.req.Trailers); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+
+	if size > b.src.limits.MaxBodyBytes-b.total {
+		return ERROR_BODY_TOO_LARGE
+	}
+
+	b.remaining = size
+	b.inChunk = true
+	return nil
+}
+
+// at most this much of an unread body is read away on Close, a client
+// sending more costs the connection instead of server time
+const maxDrain = 256 << 10
+
+// Close drains what the handler left unread, so the next request
+// on a keep-alive connection starts at the right byte. More than
+// maxDrain left fails with ERROR_BODY_NOT_DRAINED, the connection
+// can't be reused then.
+func (b *body) Close() error {
+	if b.closed {
+		return nil
+	}
+
+	var err error
+	if !b.sawEOF && b.err == nil {
+		_, err = io.CopyN(io.Discard, b, maxDrain+1)
+		switch err {
+		case nil:
+			err = ERROR_BODY_NOT_DRAINED
+		case io.EOF:
+			err = nil
+		}
+	} else if b.err != nil {
+		err = b.err
+	}
+	b.closed = true
+	return err
+}
+
+// parses a chunk-size line, extensions are ignored
+//
+//	chunk      = chunk-size [ chunk-ext ] CRLF chunk-data CRLF
+//	chunk-ext  = *( BWS ";" BWS chunk-ext-name [ BWS "=" BWS chunk-ext-val ] )
+func parseChunkSize(line []byte) (int64, error) {
+	if i := bytes.IndexByte(line, ';'); i != -1 {
+		line = line[:i]
+	}
+	line = bytes.TrimRight(line, " \t")
+
+	size, err := strconv.ParseUint(string(line), 16, 63)
+	if err != nil {
+		return 0, ERROR_BAD_CHUNK
+	}
+	return int64(size), nil
+}
@@ -0,0 +1,79 @@
+package request
+
+import (
+	"errors"
+	"net"
+
+	"github.com/kalim-Asim/http-server/internal/headers"
+)
+
+// Error is a request parse error together with the response
+// status it implies and a short reason for the client
+type Error struct {
+	StatusCode int
+	Reason     string
+	Err        error // underlying cause, may be nil
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Reason + ": " + e.Err.Error()
+	}
+	return e.Reason
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// StatusFor returns the status code a response to err should carry.
+// Errors without one (including header errors wrapped by the parser)
+// fall back to 400, a read timeout to 408.
+func StatusFor(err error) int {
+	var reqErr *Error
+	if errors.As(err, &reqErr) {
+		// a cause from the headers package is more specific
+		var headerErr *headers.Error
+		if errors.As(reqErr.Err, &headerErr) {
+			return headerErr.StatusCode
+		}
+		return reqErr.StatusCode
+	}
+
+	var headerErr *headers.Error
+	if errors.As(err, &headerErr) {
+		return headerErr.StatusCode
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return 408
+	}
+
+	return 400
+}
+
+// ReasonFor returns a short human readable reason for err,
+// safe to send back to the client
+func ReasonFor(err error) string {
+	if errors.Is(err, ERROR_UNEXPECTED_EOF) {
+		return ERROR_UNEXPECTED_EOF.Reason
+	}
+
+	var reqErr *Error
+	if errors.As(err, &reqErr) {
+		return reqErr.Error()
+	}
+
+	var headerErr *headers.Error
+	if errors.As(err, &headerErr) {
+		return headerErr.Reason
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timed out reading request"
+	}
+
+	return "malformed request"
+}
@@ -0,0 +1,66 @@
+package request
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/kalim-Asim/http-server/internal/headers"
+)
+
+// message framing as of RFC 9112 §6.3. A request whose length could be
+// read two ways is rejected outright, a proxy in front of us might have
+// picked the other one (request smuggling)
+
+var (
+	ERROR_AMBIGUOUS_FRAMING          = &Error{StatusCode: 400, Reason: "both transfer-encoding and content-length present"}
+	ERROR_BAD_CONTENT_LENGTH         = &Error{StatusCode: 400, Reason: "invalid content-length"}
+	ERROR_CONFLICTING_CONTENT_LENGTH = &Error{StatusCode: 400, Reason: "conflicting content-length values"}
+	ERROR_CHUNKED_NOT_LAST           = &Error{StatusCode: 400, Reason: "chunked is not the final transfer coding"}
+)
+
+// decides how the body of r is delimited and records it in
+// ContentLength / chunked
+func (r *Request) setFraming() error {
+	hasTE := r.Headers.Has("transfer-encoding")
+	hasCL := r.Headers.Has("content-length")
+
+	if hasTE && hasCL {
+		return ERROR_AMBIGUOUS_FRAMING
+	}
+
+	if hasTE {
+		codings := r.Headers.List("transfer-encoding")
+		if len(codings) == 0 {
+			return ERROR_UNSUPPORTED_TRANSFER_CODING
+		}
+		for i, coding := range codings {
+			last := i == len(codings)-1
+			if strings.EqualFold(coding, "chunked") != last {
+				// chunked must be applied exactly once, at the end
+				return ERROR_CHUNKED_NOT_LAST
+			}
+		}
+		// chunked is the only coding we know how to decode
+		if len(codings) > 1 {
+			return ERROR_UNSUPPORTED_TRANSFER_CODING
+		}
+
+		r.chunked = true
+		r.ContentLength = -1
+		return nil
+	}
+
+	if hasCL {
+		// repeated fields are only accepted when they carry the same value
+		length, err := r.Headers.Int("content-length")
+		if errors.Is(err, headers.ERROR_CONFLICTING_VALUES) {
+			return ERROR_CONFLICTING_CONTENT_LENGTH
+		}
+		if err != nil {
+			return ERROR_BAD_CONTENT_LENGTH
+		}
+		r.ContentLength = length
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package request
+
+var (
+	ERROR_REQUEST_LINE_TOO_LONG = &Error{StatusCode: 414, Reason: "request line too long"}
+	ERROR_HEADERS_TOO_LARGE     = &Error{StatusCode: 431, Reason: "header fields too large"}
+	ERROR_TOO_MANY_HEADERS      = &Error{StatusCode: 431, Reason: "too many header fields"}
+	ERROR_BODY_TOO_LARGE        = &Error{StatusCode: 413, Reason: "body too large"}
+	ERROR_BUFFER_FULL           = &Error{StatusCode: 400, Reason: "line does not fit into the read buffer"}
+)
+
+// Limits bounds how much a single request may send.
+// A zero field falls back to the matching DefaultLimits value.
+type Limits struct {
+	MaxRequestLineBytes int   // request line, without the CRLF
+	MaxHeaderBytes      int   // all field lines together (also applies to trailers)
+	MaxHeaderCount      int   // number of field lines
+	MaxBodyBytes        int64 // decoded body, for both framings
+}
+
+var DefaultLimits = Limits{
+	MaxRequestLineBytes: 8 << 10,
+	MaxHeaderBytes:      64 << 10,
+	MaxHeaderCount:      100,
+	MaxBodyBytes:        10 << 20,
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxRequestLineBytes <= 0 {
+		l.MaxRequestLineBytes = DefaultLimits.MaxRequestLineBytes
+	}
+	if l.MaxHeaderBytes <= 0 {
+		l.MaxHeaderBytes = DefaultLimits.MaxHeaderBytes
+	}
+	if l.MaxHeaderCount <= 0 {
+		l.MaxHeaderCount = DefaultLimits.MaxHeaderCount
+	}
+	if l.MaxBodyBytes <= 0 {
+		l.MaxBodyBytes = DefaultLimits.MaxBodyBytes
+	}
+	return l
+}
+
+// largest the read buffer has to grow, big enough for an
+// incomplete line of either kind to trip its own limit first
+func (l Limits) maxBuffer() int {
+	return max(l.MaxRequestLineBytes, l.MaxHeaderBytes) + 2*len(SEPARATOR)
+}
@@ -2,10 +2,12 @@ package request
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
-	"strconv"
+	"net/url"
+	"strings"
 
 	"github.com/kalim-Asim/http-server/internal/headers"
 )
@@ -30,9 +32,16 @@ HTTP-message   = start-line CRLF
 
 var (
 	SEPARATOR = []byte("\r\n")
-	ERROR_BAD_START_LINE = fmt.Errorf("bad start line")
-	ERROR_REQUEST_IN_ERROR_STATE = fmt.Errorf("request in error state")
-	ERROR_UNSUPPORTED_HTTP_VERSION = fmt.Errorf("http version not supported")
+	ERROR_BAD_START_LINE = &Error{StatusCode: 400, Reason: "bad start line"}
+	ERROR_REQUEST_IN_ERROR_STATE = &Error{StatusCode: 400, Reason: "request in error state"}
+	ERROR_UNSUPPORTED_HTTP_VERSION = &Error{StatusCode: 505, Reason: "http version not supported"}
+	ERROR_UNSUPPORTED_TRANSFER_CODING = &Error{StatusCode: 501, Reason: "transfer coding not implemented"}
+	ERROR_BAD_CHUNK = &Error{StatusCode: 400, Reason: "malformed chunk"}
+	// the connection ended in the middle of a request, wraps io.ErrUnexpectedEOF
+	ERROR_UNEXPECTED_EOF = &Error{StatusCode: 400, Reason: "unexpected end of request", Err: io.ErrUnexpectedEOF}
+	ERROR_BODY_CLOSED = fmt.Errorf("read on closed body")
+	// Close left too much of the body unread to reuse the connection
+	ERROR_BODY_NOT_DRAINED = fmt.Errorf("body too large to drain")
 )
 
 // parser state machine, to track parser progress
@@ -40,7 +49,6 @@ type parserState string
 const (
 	StateInit parserState = "init"
 	StateHeader parserState = "header"
-	StateBody parserState = "body"
 	StateDone parserState = "done"
 	StateError parserState = "error"
 )
@@ -58,13 +66,34 @@ type Request struct {
 	RequestLine RequestLine // holds the parse requestline(first line)
 	State parserState
 	Headers headers.Headers // headers parsed
-	Body string
+	// streamed from the connection on demand, it is never nil
+	// (NoBody when the request has no message body)
+	Body io.ReadCloser
+	// trailer fields sent after a chunked body,
+	// only filled in once Body has been read to io.EOF
+	Trailers *headers.Headers
+	// declared body length, -1 for a chunked body
+	ContentLength int64
+	// named segments of the matched route pattern, set by the router
+	PathParams map[string]string
+
+	// see Context and WithContext
+	ctx context.Context
+
+	chunked bool
+
+	limits      Limits
+	headerBytes int // field line bytes consumed so far
+	headerCount int
 }
 
 func NewRequest() *Request {
 	return &Request{
 		State: StateInit,
 		Headers: *headers.NewHeaders(), 
+		Body: NoBody,
+		Trailers: headers.NewHeaders(),
+		limits: DefaultLimits,
 	}
 }
 
@@ -86,10 +115,14 @@ func parseRequestLine(b []byte) (*RequestLine, int, error) {
 	}
 	
 	httpParts := bytes.Split(parts[2], []byte("/"))
-	// valid http
-	if len(httpParts) != 2 || string(httpParts[0]) != "HTTP" || string(httpParts[1]) != "1.1" {
+	// HTTP-version = HTTP-name "/" DIGIT "." DIGIT
+	if len(httpParts) != 2 || string(httpParts[0]) != "HTTP" || !isVersion(httpParts[1]) {
 		return nil, 0, ERROR_BAD_START_LINE
 	}
+	// well formed, but only 1.1 is spoken here
+	if string(httpParts[1]) != "1.1" {
+		return nil, 0, ERROR_UNSUPPORTED_HTTP_VERSION
+	}
 
 	rl := &RequestLine{
 		Method: string(parts[0]),
@@ -100,16 +133,15 @@ func parseRequestLine(b []byte) (*RequestLine, int, error) {
 	return rl, read, nil 
 }
 
-func getLength(r headers.Headers, key string) int {
-	var length int = 0 
-	if r.Has(key) {
-		length, _ = strconv.Atoi(r.Get(key))
-	}
-	return length 
+func isVersion(v []byte) bool {
+	return len(v) == 3 &&
+		v[0] >= '0' && v[0] <= '9' &&
+		v[1] == '.' &&
+		v[2] >= '0' && v[2] <= '9'
 }
+
 func (r *Request) hasBody() bool {
-	length := getLength(r.Headers, "content-length")
-	return length > 0 
+	return r.ContentLength > 0 || r.chunked
 }
 
 // It accepts the next slice of bytes that needs to be parsed into the Request struct.
@@ -135,6 +167,11 @@ outer:
 			if err != nil {
 				return 0, err 
 			}
+			if n - len(SEPARATOR) > r.limits.MaxRequestLineBytes ||
+				n == 0 && len(currentData) > r.limits.MaxRequestLineBytes {
+				r.State = StateError
+				return 0, ERROR_REQUEST_LINE_TOO_LONG
+			}
 			if n == 0 {
 				break outer 
 			}
@@ -148,34 +185,37 @@ outer:
 			n, done, err := r.Headers.Parse(currentData)
 			if err != nil {
 				r.State = StateError
-				return 0, fmt.Errorf("error parsing header... ")
+				return 0, &Error{StatusCode: 400, Reason: "error parsing header", Err: err}
+			}
+			// an incomplete line counts as well, otherwise a single
+			// endless field line would never trip the limit. Anything
+			// after a complete line is left for the next round, after
+			// the empty line it is body or the next request
+			size := n
+			if rest := currentData[n:]; !done && !bytes.Contains(rest, SEPARATOR) {
+				size += len(rest)
+			}
+			if r.headerBytes + size > r.limits.MaxHeaderBytes + len(SEPARATOR) {
+				r.State = StateError
+				return 0, ERROR_HEADERS_TOO_LARGE
 			}
 			if n == 0 {
 				break outer
 			}
 			read += n 
-
+			r.headerBytes += n
+			r.headerCount += bytes.Count(currentData[:n], SEPARATOR)
 			if done {
-				if r.hasBody() {
-					r.State = StateBody
-				} else {
-					r.State = StateDone
-				}
+				r.headerCount-- // the empty line
 			}
-
-		case StateBody:
-
-			length := getLength(r.Headers, "content-length")
-			if length == 0 {
-					panic("chuncked not implemented")
+			if r.headerCount > r.limits.MaxHeaderCount {
+				r.State = StateError
+				return 0, ERROR_TOO_MANY_HEADERS
 			}
 
-			remaining := min(len(currentData), length - len(r.Body))
-			r.Body += string(currentData[:remaining])
-			read += remaining
-
-			if len(r.Body) == length {
-					r.State = StateDone
+			if done {
+				// the body is streamed by Request.Body
+				r.State = StateDone
 			}
 
 		case StateDone:
@@ -189,31 +229,268 @@ outer:
 	return read, nil 
 }
 
-// orchestration function,
-// parse the request-line from the reader
-func RequestFromReader(reader io.Reader) (*Request, error) {
-	req := NewRequest()
-	buf := make([]byte, 1024)
-	bufLen := 0
+// Reader parses consecutive requests off a single connection.
+// bytes that arrive after one request (pipelining) stay in buf
+// and are used for the next one instead of being thrown away
+type Reader struct {
+	reader io.Reader
+	buf    []byte
+	bufLen int
+	limits Limits
+	// body of the last request, drained before the next one is parsed
+	body io.ReadCloser
+}
 
-	for !req.done() {
-		slog.Info("RequestFromReader", "state", req.State)
+func NewReader(reader io.Reader) *Reader {
+	return NewReaderLimits(reader, DefaultLimits)
+}
 
-		n, err := reader.Read(buf[bufLen:])
-		if err != nil {
-				return nil, err
+// NewReaderLimits returns a Reader that rejects requests going
+// over limits. The buffer starts at 1 KiB and grows on demand.
+func NewReaderLimits(reader io.Reader, limits Limits) *Reader {
+	return &Reader{
+		reader: reader,
+		buf:    make([]byte, 1024),
+		limits: limits.withDefaults(),
+	}
+}
+
+// Buffered returns the number of bytes already read from the
+// connection that belong to the next request
+func (r *Reader) Buffered() int {
+	return r.bufLen
+}
+
+// Wait blocks until the first byte of the next request is buffered,
+// so a server can tell an idle connection from a slow request.
+// It returns io.EOF if the client closed the connection instead.
+func (r *Reader) Wait() error {
+	if r.body != nil {
+		if err := r.body.Close(); err != nil {
+			return err
 		}
+		r.body = nil
+	}
 
-		bufLen += n
+	for r.bufLen == 0 {
+		if err := r.fill(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		readN, parseErr := req.parse(buf[:bufLen])
+// ReadAhead reads once from the connection into the buffer without
+// parsing anything. A server uses it while a handler runs to notice a
+// client that hung up, data that does arrive is kept for the next request.
+// It must not run concurrently with a read of the request body.
+func (r *Reader) ReadAhead() error {
+	return r.fill()
+}
+
+// ReadRequest parses the request line and headers of the next request.
+// It returns as soon as the headers are done, the body is read
+// lazily through Request.Body.
+// It returns io.EOF if the connection was closed cleanly before
+// any byte of a new request arrived.
+func (r *Reader) ReadRequest() (*Request, error) {
+	// skip whatever the handler left unread of the previous body
+	if r.body != nil {
+		if err := r.body.Close(); err != nil {
+			return nil, err
+		}
+		r.body = nil
+	}
+
+	req := NewRequest()
+	req.limits = r.limits
+
+	for {
+		// leftover bytes may already hold (part of) the next request
+		readN, parseErr := req.parse(r.buf[:r.bufLen])
 		if parseErr != nil {
-				return nil, parseErr
+			return nil, parseErr
+		}
+		r.consume(readN)
+
+		if req.done() {
+			break
+		}
+
+		slog.Info("ReadRequest", "state", req.State)
+
+		if err := r.fill(); err != nil {
+			if err == io.EOF && (req.State != StateInit || r.bufLen > 0) {
+				return nil, ERROR_UNEXPECTED_EOF
+			}
+			return nil, err
+		}
+	}
+
+	if err := req.setFraming(); err != nil {
+		return nil, err
+	}
+
+	if req.ContentLength > r.limits.MaxBodyBytes {
+		return nil, ERROR_BODY_TOO_LARGE
+	}
+
+	if req.hasBody() {
+		req.Body = newBody(r, req)
+		r.body = req.Body
+	}
+
+	return req, nil
+}
+
+// drops the first n bytes of the buffer
+func (r *Reader) consume(n int) {
+	copy(r.buf, r.buf[n:r.bufLen])
+	r.bufLen -= n
+}
+
+// reads more data from the connection into the buffer.
+// turning io.EOF into io.ErrUnexpectedEOF when it cuts
+// a message short is up to the caller
+func (r *Reader) fill() error {
+	if r.bufLen == len(r.buf) {
+		if len(r.buf) >= r.limits.maxBuffer() {
+			return ERROR_BUFFER_FULL
 		}
+		buf := make([]byte, min(2*len(r.buf), r.limits.maxBuffer()))
+		copy(buf, r.buf[:r.bufLen])
+		r.buf = buf
+	}
+
+	n, err := r.reader.Read(r.buf[r.bufLen:])
+	r.bufLen += n
+	if n > 0 {
+		// an error that came along with data shows up on the next read
+		return nil
+	}
+	return err
+}
+
+// read serves buffered bytes first and only then
+// reads from the connection
+func (r *Reader) read(p []byte) (int, error) {
+	if r.bufLen > 0 {
+		n := copy(p, r.buf[:r.bufLen])
+		r.consume(n)
+		return n, nil
+	}
+	return r.reader.Read(p)
+}
 
-		copy(buf, buf[readN:bufLen])
-		bufLen -= readN
+// returns the next CRLF terminated line without the CRLF
+func (r *Reader) readLine() ([]byte, error) {
+	for {
+		if idx := bytes.Index(r.buf[:r.bufLen], SEPARATOR); idx != -1 {
+			line := bytes.Clone(r.buf[:idx])
+			r.consume(idx + len(SEPARATOR))
+			return line, nil
+		}
+		if err := r.fill(); err != nil {
+			if err == io.EOF {
+				return nil, ERROR_UNEXPECTED_EOF
+			}
+			return nil, err
+		}
 	}
+}
 
-	return req, nil 	
-}
\ No newline at end of file
+// parses field lines into h until the empty line,
+// bounded by the same limits as the header section
+func (r *Reader) readFields(h *headers.Headers) error {
+	size, count := 0, 0
+	for {
+		n, done, err := h.Parse(r.buf[:r.bufLen])
+		if err != nil {
+			return &Error{StatusCode: 400, Reason: "error parsing trailer", Err: err}
+		}
+		size += n
+		count += bytes.Count(r.buf[:n], SEPARATOR)
+		if size > r.limits.MaxHeaderBytes + len(SEPARATOR) {
+			return ERROR_HEADERS_TOO_LARGE
+		}
+		if count > r.limits.MaxHeaderCount + 1 {
+			return ERROR_TOO_MANY_HEADERS
+		}
+		r.consume(n)
+
+		if done {
+			return nil
+		}
+		if n > 0 {
+			continue
+		}
+		if err := r.fill(); err != nil {
+			if err == io.EOF {
+				return ERROR_UNEXPECTED_EOF
+			}
+			return err
+		}
+	}
+}
+
+// orchestration function,
+// parse the request-line from the reader
+func RequestFromReader(reader io.Reader) (*Request, error) {
+	return NewReader(reader).ReadRequest()
+}
+
+// Context returns the request context. The server cancels it when the
+// connection closes, when the server shuts down or when the request
+// deadline expires. It is never nil.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// WithContext returns a shallow copy of r carrying ctx, the way
+// middleware attaches values for the handlers after it. Body and
+// Trailers are shared with r, Headers are copied.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
+	}
+	r2 := *r
+	r2.Headers = *r.Headers.Clone()
+	r2.ctx = ctx
+	return &r2
+}
+
+// Path returns the (still percent-encoded) path of the request target,
+// without the query. Absolute-form targets are reduced to their path.
+func (r *Request) Path() string {
+	u, err := url.ParseRequestURI(r.RequestLine.RequestTarget)
+	if err != nil {
+		path, _, _ := strings.Cut(r.RequestLine.RequestTarget, "?")
+		return path
+	}
+	if u.Opaque != "" {
+		return u.Opaque
+	}
+	return u.EscapedPath()
+}
+
+// Query parses the query string of the request target
+func (r *Request) Query() url.Values {
+	_, query, _ := strings.Cut(r.RequestLine.RequestTarget, "?")
+	values, _ := url.ParseQuery(query)
+	return values
+}
+
+// PathParam returns the value of the named route segment,
+// "" if the route has no such segment
+func (r *Request) PathParam(name string) string {
+	return r.PathParams[name]
+}
+
+// KeepAlive reports whether the client allows the connection
+// to be reused after this request (HTTP/1.1 default is yes)
+func (r *Request) KeepAlive() bool {
+	return !r.Headers.HasToken("connection", "close")
+}
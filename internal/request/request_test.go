@@ -1,9 +1,12 @@
 package request
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"testing"
 	"strings"
+	"github.com/kalim-Asim/http-server/internal/headers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -30,6 +33,14 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
+// reads the whole streamed body of r
+func readBody(t *testing.T, r *Request) string {
+	t.Helper()
+	b, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	return string(b)
+}
+
 func TestRequestLineParse(t *testing.T) {
 	// Test: Good GET Request line
 	reader := &chunkReader{
@@ -87,6 +98,14 @@ func TestParseHeaders(t *testing.T) {
 	}
 	r, err = RequestFromReader(reader)
 	require.Error(t, err)
+
+	// Test: Control characters in a value
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost\x00:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	assert.ErrorIs(t, err, headers.ERROR_INVALID_FIELD_VALUE)
 }
 
 func TestParseBody(t *testing.T) {
@@ -102,7 +121,7 @@ func TestParseBody(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Equal(t, "hello world!\n", readBody(t, r))
 
 	// Test: Empty Body, 0 reported content length (valid)
 	reader = &chunkReader{
@@ -115,7 +134,7 @@ func TestParseBody(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Len(t, r.Body, 0)
+	assert.Empty(t, readBody(t, r))
 
 	// Test: Empty Body, no reported content length (valid)
 	reader = &chunkReader{
@@ -127,7 +146,7 @@ func TestParseBody(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Len(t, r.Body, 0)
+	assert.Empty(t, readBody(t, r))
 
 	// Test: Body shorter than reported content length (should error)
 	reader = &chunkReader{
@@ -139,8 +158,10 @@ func TestParseBody(t *testing.T) {
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
 	require.Error(t, err)
-	assert.Nil(t, r)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
 
 	// Test: No Content-Length but Body Exists (shouldn't error)
 	// Assumption: Content-Length will be present if a body exists,
@@ -156,5 +177,425 @@ func TestParseBody(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "body without content length", string(r.Body))
+	assert.Equal(t, "body without content length", readBody(t, r))
+}
+
+func TestReadRequestKeepAlive(t *testing.T) {
+	// Test: Two pipelined requests on one connection
+	reader := NewReader(&chunkReader{
+		data: "POST /first HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"GET /second HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Connection: close\r\n" +
+			"\r\n",
+		numBytesPerRead: 7,
+	})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", readBody(t, r))
+	assert.True(t, r.KeepAlive())
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	assert.False(t, r.KeepAlive())
+
+	// Test: Clean close between requests
+	_, err = reader.ReadRequest()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Connection closed in the middle of a request
+	reader = NewReader(strings.NewReader("GET / HTTP/1.1\r\nHost: local"))
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestParseChunkedBody(t *testing.T) {
+	// Test: Chunked body with extension and trailers
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Checksum\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"7;name=value\r\n, world\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc123\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Empty(t, r.Trailers.Get("x-checksum")) // not read yet
+	assert.Equal(t, "hello, world", readBody(t, r))
+	assert.Equal(t, "abc123", r.Trailers.Get("x-checksum"))
+
+	// Test: Chunked body without trailers, uppercase hex size
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"A\r\n0123456789\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", readBody(t, r))
+
+	// Test: Invalid chunk size
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"zz\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.Error(t, err)
+
+	// Test: Chunk data longer than its declared size
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.Error(t, err)
+
+	// Test: Connection closed before the last chunk
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.Error(t, err)
+}
+
+func TestUnreadBodyIsDrained(t *testing.T) {
+	// Test: Handler ignores the body, next request still parses
+	reader := NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n0\r\n\r\n" +
+			"POST /form HTTP/1.1\r\n" +
+			"Content-Length: 3\r\n" +
+			"\r\n" +
+			"a=b" +
+			"GET /last HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 4,
+	})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/upload", r.RequestLine.RequestTarget)
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/form", r.RequestLine.RequestTarget)
+
+	// partly read body
+	b := make([]byte, 1)
+	_, err = r.Body.Read(b)
+	require.NoError(t, err)
+	require.NoError(t, r.Body.Close())
+	_, err = r.Body.Read(b)
+	assert.Equal(t, ERROR_BODY_CLOSED, err)
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/last", r.RequestLine.RequestTarget)
+	assert.Equal(t, NoBody, r.Body)
+}
+
+func TestUnreadBodyDrainLimit(t *testing.T) {
+	// Test: Close reads a large unread body only up to maxDrain
+	reader := NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			fmt.Sprintf("Content-Length: %d\r\n", maxDrain*2) +
+			"\r\n" +
+			strings.Repeat("a", maxDrain*2),
+		numBytesPerRead: 4096,
+	})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, ERROR_BODY_NOT_DRAINED, r.Body.Close())
+
+	// Test: exactly maxDrain bytes left still drain
+	reader = NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			fmt.Sprintf("Content-Length: %d\r\n", maxDrain) +
+			"\r\n" +
+			strings.Repeat("a", maxDrain) +
+			"GET /next HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 4096,
+	})
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	require.NoError(t, r.Body.Close())
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+}
+
+func TestLimits(t *testing.T) {
+	limits := Limits{
+		MaxRequestLineBytes: 64,
+		MaxHeaderBytes:      128,
+		MaxHeaderCount:      3,
+		MaxBodyBytes:        10,
+	}
+
+	// Test: Request line and headers longer than the initial 1 KiB buffer
+	longPath := "/" + strings.Repeat("a", 2000)
+	longValue := strings.Repeat("b", 3000)
+	reader := NewReader(&chunkReader{
+		data:            "GET " + longPath + " HTTP/1.1\r\nX-Long: " + longValue + "\r\n\r\n",
+		numBytesPerRead: 100,
+	})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, longPath, r.RequestLine.RequestTarget)
+	assert.Equal(t, longValue, r.Headers.Get("x-long"))
+
+	// Test: Request line over the limit
+	reader = NewReaderLimits(&chunkReader{
+		data:            "GET " + longPath + " HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 3,
+	}, limits)
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_REQUEST_LINE_TOO_LONG)
+
+	// Test: Endless request line without CRLF
+	reader = NewReaderLimits(strings.NewReader("GET "+longPath), limits)
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_REQUEST_LINE_TOO_LONG)
+
+	// Test: Header section over the limit
+	reader = NewReaderLimits(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nX-Long: " + longValue + "\r\n\r\n",
+		numBytesPerRead: 3,
+	}, limits)
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_HEADERS_TOO_LARGE)
+
+	// Test: Too many header lines
+	reader = NewReaderLimits(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\nD: 4\r\n\r\n",
+		numBytesPerRead: 3,
+	}, limits)
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_TOO_MANY_HEADERS)
+
+	// Test: Exactly at the header count limit
+	reader = NewReaderLimits(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\n\r\n",
+		numBytesPerRead: 3,
+	}, limits)
+	_, err = reader.ReadRequest()
+	assert.NoError(t, err)
+
+	// Test: Body bytes read along with the headers don't count as headers
+	bodyLimits := limits
+	bodyLimits.MaxBodyBytes = 1000
+	body := strings.Repeat("x", 500)
+	reader = NewReaderLimits(strings.NewReader(
+		"POST / HTTP/1.1\r\nContent-Length: 500\r\n\r\n"+body), bodyLimits)
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, body, readBody(t, r))
+
+	// Test: Neither do pipelined requests read along with the headers
+	reader = NewReaderLimits(strings.NewReader(
+		strings.Repeat("GET / HTTP/1.1\r\nHost: a\r\n\r\n", 10)), limits)
+	for range 10 {
+		_, err = reader.ReadRequest()
+		require.NoError(t, err)
+	}
+
+	// Test: Declared Content-Length over the limit
+	reader = NewReaderLimits(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nContent-Length: 11\r\n\r\nhello world",
+		numBytesPerRead: 3,
+	}, limits)
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_BODY_TOO_LARGE)
+
+	// Test: Chunked body growing over the limit
+	reader = NewReaderLimits(&chunkReader{
+		data: "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"6\r\nhello \r\n5\r\nworld\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}, limits)
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	assert.ErrorIs(t, err, ERROR_BODY_TOO_LARGE)
+}
+
+// net.Error reporting a timeout, like an expired read deadline
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+type timeoutReader struct{}
+
+func (timeoutReader) Read([]byte) (int, error) { return 0, timeoutError{} }
+
+func TestErrorStatus(t *testing.T) {
+	parse := func(data string) error {
+		_, err := RequestFromReader(strings.NewReader(data))
+		return err
+	}
+
+	// Test: Unsupported but well formed version
+	err := parse("GET / HTTP/1.0\r\n\r\n")
+	assert.ErrorIs(t, err, ERROR_UNSUPPORTED_HTTP_VERSION)
+	assert.Equal(t, 505, StatusFor(err))
+
+	// Test: Malformed version
+	err = parse("GET / HTTP/one\r\n\r\n")
+	assert.ErrorIs(t, err, ERROR_BAD_START_LINE)
+	assert.Equal(t, 400, StatusFor(err))
+
+	// Test: Unknown transfer coding
+	err = parse("POST / HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n")
+	assert.ErrorIs(t, err, ERROR_UNSUPPORTED_TRANSFER_CODING)
+	assert.Equal(t, 501, StatusFor(err))
+
+	// Test: Bad header name keeps the headers package reason
+	err = parse("GET / HTTP/1.1\r\nH@st: localhost\r\n\r\n")
+	assert.Equal(t, 400, StatusFor(err))
+	assert.Contains(t, ReasonFor(err), "field name is invalid")
+
+	// Test: Early EOF
+	err = parse("GET / HTTP/1.1\r\nHost: loc")
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, "unexpected end of request", ReasonFor(err))
+
+	// Test: Limits map to their own statuses
+	assert.Equal(t, 414, StatusFor(ERROR_REQUEST_LINE_TOO_LONG))
+	assert.Equal(t, 431, StatusFor(ERROR_TOO_MANY_HEADERS))
+	assert.Equal(t, 413, StatusFor(ERROR_BODY_TOO_LARGE))
+
+	// Test: Read timeout
+	_, err = RequestFromReader(timeoutReader{})
+	assert.Equal(t, 408, StatusFor(err))
+	assert.Equal(t, "timed out reading request", ReasonFor(err))
+}
+
+func TestFraming(t *testing.T) {
+	parse := func(fields string) (*Request, error) {
+		return RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\n" + fields + "\r\n"))
+	}
+
+	// Test: Transfer-Encoding and Content-Length together
+	_, err := parse("Transfer-Encoding: chunked\r\nContent-Length: 5\r\n")
+	assert.ErrorIs(t, err, ERROR_AMBIGUOUS_FRAMING)
+
+	// Test: Same, Content-Length first
+	_, err = parse("Content-Length: 5\r\nTransfer-Encoding: chunked\r\n")
+	assert.ErrorIs(t, err, ERROR_AMBIGUOUS_FRAMING)
+
+	// Test: Content-Length folded into the line before (obs-fold)
+	_, err = parse("Host: a\r\n Content-Length: 5\r\n")
+	assert.ErrorIs(t, err, headers.ERROR_OBS_FOLD)
+	assert.Equal(t, 400, StatusFor(err))
+
+	// Test: Duplicate Content-Length with different values
+	_, err = parse("Content-Length: 5\r\nContent-Length: 6\r\n")
+	assert.ErrorIs(t, err, ERROR_CONFLICTING_CONTENT_LENGTH)
+
+	// Test: Comma separated list with different values
+	_, err = parse("Content-Length: 5, 6\r\n")
+	assert.ErrorIs(t, err, ERROR_CONFLICTING_CONTENT_LENGTH)
+
+	// Test: Duplicate Content-Length with the same value is fine
+	r, err := parse("Content-Length: 0\r\nContent-Length: 0\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), r.ContentLength)
+
+	// Test: Negative Content-Length
+	_, err = parse("Content-Length: -1\r\n")
+	assert.ErrorIs(t, err, ERROR_BAD_CONTENT_LENGTH)
+
+	// Test: Signed Content-Length
+	_, err = parse("Content-Length: +5\r\n")
+	assert.ErrorIs(t, err, ERROR_BAD_CONTENT_LENGTH)
+
+	// Test: Non-digit Content-Length
+	_, err = parse("Content-Length: 0x10\r\n")
+	assert.ErrorIs(t, err, ERROR_BAD_CONTENT_LENGTH)
+
+	// Test: Empty Content-Length
+	_, err = parse("Content-Length: \r\n")
+	assert.ErrorIs(t, err, ERROR_BAD_CONTENT_LENGTH)
+
+	// Test: Content-Length overflowing int64
+	_, err = parse("Content-Length: 99999999999999999999\r\n")
+	assert.ErrorIs(t, err, ERROR_BAD_CONTENT_LENGTH)
+
+	// Test: chunked not the final coding
+	_, err = parse("Transfer-Encoding: chunked, gzip\r\n")
+	assert.ErrorIs(t, err, ERROR_CHUNKED_NOT_LAST)
+
+	// Test: Coding without chunked at all
+	_, err = parse("Transfer-Encoding: gzip\r\n")
+	assert.ErrorIs(t, err, ERROR_CHUNKED_NOT_LAST)
+
+	// Test: chunked applied twice
+	_, err = parse("Transfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n")
+	assert.ErrorIs(t, err, ERROR_CHUNKED_NOT_LAST)
+
+	// Test: Empty Transfer-Encoding
+	_, err = parse("Transfer-Encoding: \r\n")
+	assert.ErrorIs(t, err, ERROR_UNSUPPORTED_TRANSFER_CODING)
+
+	// Test: chunked is case-insensitive
+	r, err = parse("Transfer-Encoding: Chunked\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), r.ContentLength)
+
+	// Test: Every rejection maps to 400
+	for _, e := range []error{ERROR_AMBIGUOUS_FRAMING, ERROR_BAD_CONTENT_LENGTH,
+		ERROR_CONFLICTING_CONTENT_LENGTH, ERROR_CHUNKED_NOT_LAST} {
+		assert.Equal(t, 400, StatusFor(e))
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET /a?b=c HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, context.Background(), r.Context())
+
+	type key struct{}
+	r2 := r.WithContext(context.WithValue(r.Context(), key{}, "value"))
+	assert.Equal(t, "value", r2.Context().Value(key{}))
+	assert.Nil(t, r.Context().Value(key{}))
+	assert.Equal(t, r.RequestLine, r2.RequestLine)
+	assert.Equal(t, "/a", r2.Path())
+	assert.Equal(t, "c", r2.Query().Get("b"))
+
+	assert.Panics(t, func() { r.WithContext(nil) })
 }
@@ -0,0 +1,183 @@
+package response
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/kalim-Asim/http-server/internal/headers"
+)
+
+// bodies up to this size get a Content-Length, larger ones are chunked
+const DefaultBufferSize = 4096
+
+// AutoWriter is a Writer for handlers that do not want to deal with framing.
+// Header() can be changed until the first byte is sent, WriteHeader only
+// records the status. The body is buffered: a response done within the
+// buffer goes out with a Content-Length, a longer one is sent chunked,
+// unless the handler set a Content-Length itself. Close finishes it.
+type AutoWriter struct {
+	w      *Writer
+	header *headers.Headers
+	status StatusCode
+	buf    []byte
+	size   int
+	sent   bool // status line and headers are written
+}
+
+func NewAutoWriter(w *Writer) *AutoWriter {
+	return NewAutoWriterSize(w, DefaultBufferSize)
+}
+
+// NewAutoWriterSize buffers up to size body bytes before switching to chunked
+func NewAutoWriterSize(w *Writer, size int) *AutoWriter {
+	return &AutoWriter{
+		w:      w,
+		header: headers.NewHeaders(),
+		size:   size,
+	}
+}
+
+// Header returns the fields sent with the response,
+// changes after the first byte is sent have no effect
+func (a *AutoWriter) Header() *headers.Headers {
+	return a.header
+}
+
+// WriteHeader sets the status, 200 if never called. Only the first call
+// counts, except for 1xx codes: those are sent right away with the current
+// header fields and the final status is still to come.
+func (a *AutoWriter) WriteHeader(code StatusCode) {
+	if code >= 100 && code < 200 {
+		a.w.WriteInformational(code, a.header)
+		return
+	}
+	if a.status == 0 {
+		a.status = code
+	}
+}
+
+func (a *AutoWriter) Write(p []byte) (int, error) {
+	if a.status != 0 && !BodyAllowed(a.status) {
+		return 0, fmt.Errorf("%w: Write for %d", ERROR_BODY_NOT_ALLOWED, a.status)
+	}
+	if !a.sent {
+		if len(a.buf)+len(p) <= a.size {
+			a.buf = append(a.buf, p...)
+			return len(p), nil
+		}
+		if err := a.send(false, p); err != nil {
+			return 0, err
+		}
+	}
+	return a.writeBody(p)
+}
+
+// ReadFrom implements io.ReaderFrom. With the Content-Type and
+// Content-Length set there is nothing to buffer for: the headers go out
+// and r is handed to Writer.ReadFrom, so files can be sent with sendfile.
+// Otherwise it is a plain copy through Write.
+func (a *AutoWriter) ReadFrom(r io.Reader) (int64, error) {
+	direct := a.sent || a.header.Has("content-type") && a.header.Has("content-length")
+	if !direct || a.status != 0 && !BodyAllowed(a.status) {
+		return io.Copy(writerOnly{a}, r)
+	}
+	if !a.sent {
+		if err := a.send(false, nil); err != nil {
+			return 0, err
+		}
+	}
+	if a.w.State() == StateChunked {
+		return io.Copy(writerOnly{a}, r)
+	}
+	return a.w.ReadFrom(r)
+}
+
+// hides ReadFrom from io.Copy
+type writerOnly struct {
+	io.Writer
+}
+
+// Flush sends the headers and whatever is buffered,
+// the rest of the body then goes out chunked
+func (a *AutoWriter) Flush() error {
+	if a.sent {
+		return nil
+	}
+	return a.send(false, nil)
+}
+
+// Close completes the response. It has to be called once the handler is done.
+func (a *AutoWriter) Close() error {
+	if !a.sent {
+		if err := a.send(true, nil); err != nil {
+			return err
+		}
+	}
+	if a.w.State() == StateChunked {
+		if _, err := a.w.WriteChunkedBodyDone(); err != nil {
+			return err
+		}
+		return a.w.WriteTrailers(headers.NewHeaders())
+	}
+	return nil
+}
+
+// writes the status line and headers, then the buffer. done means the
+// buffer holds the whole body, so its length can be announced; next is
+// the body that follows the buffer, if already known
+func (a *AutoWriter) send(done bool, next []byte) error {
+	a.sent = true
+	if a.status == 0 {
+		a.status = StatusOK
+	}
+
+	h := a.header
+	if !h.Has("content-type") {
+		if sniff := a.sniff(next); len(sniff) > 0 {
+			h.Set("Content-Type", http.DetectContentType(sniff))
+		}
+	}
+	if BodyAllowed(a.status) && !h.Has("content-length") && !h.Has("transfer-encoding") {
+		if done {
+			h.Set("Content-Length", strconv.Itoa(len(a.buf)))
+		} else {
+			h.Set("Transfer-Encoding", "chunked")
+		}
+	}
+
+	if err := a.w.WriteStatusLine(a.status); err != nil {
+		return err
+	}
+	if err := a.w.WriteHeaders(*h); err != nil {
+		return err
+	}
+
+	buf := a.buf
+	a.buf = nil
+	if len(buf) == 0 || !BodyAllowed(a.status) {
+		return nil
+	}
+	_, err := a.writeBody(buf)
+	return err
+}
+
+// the start of the body for http.DetectContentType: the buffer,
+// topped up from next when it is shorter than what gets looked at
+func (a *AutoWriter) sniff(next []byte) []byte {
+	const sniffLen = 512
+	if len(a.buf) >= sniffLen || len(next) == 0 {
+		return a.buf
+	}
+	b := make([]byte, 0, sniffLen)
+	b = append(b, a.buf...)
+	return append(b, next[:min(len(next), sniffLen-len(a.buf))]...)
+}
+
+func (a *AutoWriter) writeBody(p []byte) (int, error) {
+	if a.w.State() == StateChunked {
+		return a.w.WriteChunkedBody(p)
+	}
+	return a.w.WriteBody(p)
+}
@@ -0,0 +1,196 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/kalim-Asim/http-server/internal/headers"
+)
+
+var ERROR_UNDECLARED_TRAILER = errors.New("trailer not declared in the Trailer header")
+var ERROR_FORBIDDEN_TRAILER = errors.New("field not allowed in trailers")
+
+// fields a recipient needs before the body (framing, routing, auth,
+// content handling), they must never arrive as trailers (RFC 9110 6.5.1)
+var forbiddenTrailers = map[string]bool{
+	"authorization":       true,
+	"cache-control":       true,
+	"connection":          true,
+	"content-encoding":    true,
+	"content-length":      true,
+	"content-range":       true,
+	"content-type":        true,
+	"expect":              true,
+	"host":                true,
+	"keep-alive":          true,
+	"max-forwards":        true,
+	"pragma":              true,
+	"proxy-authenticate":  true,
+	"proxy-authorization": true,
+	"proxy-connection":    true,
+	"range":               true,
+	"te":                  true,
+	"trailer":             true,
+	"transfer-encoding":   true,
+	"www-authenticate":    true,
+}
+
+// TrailerAllowed reports whether a field may be sent as a trailer
+func TrailerAllowed(name string) bool {
+	return !forbiddenTrailers[strings.ToLower(name)]
+}
+
+// the comma separated names of a Trailer header
+func trailerNames(list string) map[string]bool {
+	names := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+func (w *Writer) checkTrailers(t *headers.Headers) error {
+	if err := checkFields(t); err != nil {
+		return err
+	}
+	var err error
+	t.ForEach(func(key, val string) {
+		name := strings.ToLower(key)
+		switch {
+		case err != nil:
+		case forbiddenTrailers[name]:
+			err = fmt.Errorf("%w: %s", ERROR_FORBIDDEN_TRAILER, key)
+		case !w.declared[name]:
+			err = fmt.Errorf("%w: %s", ERROR_UNDECLARED_TRAILER, key)
+		}
+	})
+	return err
+}
+
+// ChunkedWriter writes a chunked body as an io.WriteCloser. Small writes
+// are gathered into chunks of the buffer size, Flush sends what is buffered
+// right away. Close writes the last chunk and the trailers set on Trailer().
+// The headers have to be written first, announcing the chunked encoding
+// and the trailer names.
+type ChunkedWriter struct {
+	w       *Writer
+	buf     []byte
+	trailer *headers.Headers
+	closed  bool
+}
+
+func NewChunkedWriter(w *Writer) *ChunkedWriter {
+	return NewChunkedWriterSize(w, DefaultBufferSize)
+}
+
+// NewChunkedWriterSize gathers writes into chunks of up to size bytes,
+// DefaultBufferSize if size is not positive
+func NewChunkedWriterSize(w *Writer, size int) *ChunkedWriter {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	return &ChunkedWriter{
+		w:       w,
+		buf:     make([]byte, 0, size),
+		trailer: headers.NewHeaders(),
+	}
+}
+
+// Trailer returns the fields sent after the body on Close
+func (c *ChunkedWriter) Trailer() *headers.Headers {
+	return c.trailer
+}
+
+// DeclareTrailer allows trailers the Trailer header did not announce,
+// for senders that only learn their names while writing the body.
+// Forbidden fields stay forbidden.
+func (c *ChunkedWriter) DeclareTrailer(names ...string) {
+	if c.w.declared == nil {
+		c.w.declared = map[string]bool{}
+	}
+	for _, name := range names {
+		c.w.declared[strings.ToLower(name)] = true
+	}
+}
+
+func (c *ChunkedWriter) Write(p []byte) (int, error) {
+	if c.closed {
+		return 0, fmt.Errorf("%w: Write after Close", ERROR_OUT_OF_ORDER)
+	}
+	if len(c.buf)+len(p) <= cap(c.buf) {
+		c.buf = append(c.buf, p...)
+		return len(p), nil
+	}
+
+	if err := c.Flush(); err != nil {
+		return 0, err
+	}
+	if len(p) >= cap(c.buf) {
+		// big enough for a chunk of its own
+		return c.w.WriteChunkedBody(p)
+	}
+	c.buf = append(c.buf, p...)
+	return len(p), nil
+}
+
+// ReadFrom implements io.ReaderFrom, reading straight into the chunk buffer
+func (c *ChunkedWriter) ReadFrom(r io.Reader) (int64, error) {
+	if c.closed {
+		return 0, fmt.Errorf("%w: ReadFrom after Close", ERROR_OUT_OF_ORDER)
+	}
+
+	var total int64
+	for {
+		if len(c.buf) == cap(c.buf) {
+			if err := c.Flush(); err != nil {
+				return total, err
+			}
+		}
+
+		n, err := r.Read(c.buf[len(c.buf):cap(c.buf)])
+		c.buf = c.buf[:len(c.buf)+n]
+		total += int64(n)
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// Flush sends the buffered bytes as one chunk
+func (c *ChunkedWriter) Flush() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	_, err := c.w.WriteChunkedBody(c.buf)
+	c.buf = c.buf[:0]
+	return err
+}
+
+// Close flushes the buffer and ends the body with the last chunk and the
+// trailers. Undeclared or forbidden trailers fail before anything is sent,
+// Close can be called again once they are fixed.
+func (c *ChunkedWriter) Close() error {
+	if c.closed {
+		return nil
+	}
+	if err := c.w.checkTrailers(c.trailer); err != nil {
+		return err
+	}
+	c.closed = true
+
+	if err := c.Flush(); err != nil {
+		return err
+	}
+	if _, err := c.w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	return c.w.WriteTrailers(c.trailer)
+}
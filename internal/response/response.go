@@ -3,93 +3,306 @@ package response
 import (
 	"fmt"
 	"io"
-	"github.com/kalim-Asim/http-server/internal/headers"
-)
+	"os"
 
-type StatusCode int 
-const (
-	StatusOK StatusCode = 200 
-	StatusBadRequest StatusCode = 400
-	StatusInternalServerError StatusCode = 500
+	"github.com/kalim-Asim/http-server/internal/headers"
 )
 
-type Response struct {
-}
-
-// it should set the following headers that we always want to include in our responses
+// it should set the following headers that we always want to include in our responses.
+// Connection is left out: HTTP/1.1 connections are persistent unless
+// one side says otherwise
 func GetDefaultHeaders(contentLen int) *headers.Headers {
 	h := headers.NewHeaders()
 	h.Set("Content-Length", fmt.Sprintf("%d", contentLen))
-	h.Set("Connection", "close")
 	h.Set("Content-Type", "text/plain")
 	return h 
 }
 
 type Writer struct {
-	writer io.Writer 
+	writer *errWriter
+	// false once either side asked for "Connection: close"
+	keepAlive bool
+	// checked by WriteHeaders, true turns keepAlive off
+	closeIf func() bool
+	status StatusCode // 0 until the status line is written
+	// held from WriteStatusLine until the headers go out with it
+	statusLine []byte
+	bytesWritten int64 // body bytes, chunk framing not included
+	// fields queued with AddHeader, sent along with WriteHeaders
+	extra *headers.Headers
+	state WriterState
+	remaining int64 // body bytes still due in StateBody
+	declared map[string]bool // lower case names from the Trailer header
+	// the request was HEAD: headers as for GET, the body is dropped
+	head bool
+	casing headers.Casing // how field names are written
 }
 
 func NewWriter(w io.Writer) *Writer{
 	return &Writer{
-		writer: w, 
+		writer: &errWriter{w: w}, 
+		keepAlive: true,
 	}
 }
 
-func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
-	var line string
+// remembers the first error of the underlying writer, so the server can
+// tell why a response broke off (say an expired write deadline) even when
+// the handler ignored the error
+type errWriter struct {
+	w   io.Writer
+	err error
+}
 
-	switch statusCode {
-		case StatusOK: 
-			line = "HTTP/1.1 200 OK\r\n"
-		case StatusBadRequest: 
-			line = "HTTP/1.1 400 Bad Request\r\n"
-		case StatusInternalServerError: 
-			line = "HTTP/1.1 500 Internal Server Error\r\n"
-		default:
-			// Any other code leaves the reason phrase blank
-			line = fmt.Sprintf("HTTP/1.1 %d \r\n", statusCode)
+func (e *errWriter) Write(p []byte) (int, error) {
+	n, err := e.w.Write(p)
+	if err != nil && e.err == nil {
+		e.err = err
 	}
+	return n, err
+}
 
-	_, err := w.writer.Write([]byte(line))
-	return err
+// leaves the copy to the connection: a *net.TCPConn reading from a file
+// or another socket has the kernel move the bytes (sendfile, splice)
+func (e *errWriter) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(e.w, r)
+	if err != nil && e.err == nil {
+		e.err = err
+	}
+	return n, err
+}
+
+// Err returns the first error writing to the connection, if any
+func (w *Writer) Err() error {
+	return w.writer.err
+}
+
+// KeepAlive reports whether the connection can be reused
+// after this response
+func (w *Writer) KeepAlive() bool {
+	return w.keepAlive
+}
+
+// SetKeepAlive(false) makes WriteHeaders announce "Connection: close",
+// used when the client asked for it or the server is going away
+func (w *Writer) SetKeepAlive(keepAlive bool) {
+	w.keepAlive = keepAlive
+}
+
+// SetMethod tells the writer the request method. A HEAD response gets the
+// status line and headers a GET would, body writes succeed but nothing of
+// the body (chunk framing and trailers included) is sent.
+func (w *Writer) SetMethod(method string) {
+	w.head = method == "HEAD"
+}
+
+// SetHeaderCasing sets how field names are written, canonical
+// ("Content-Type") unless changed
+func (w *Writer) SetHeaderCasing(casing headers.Casing) {
+	w.casing = casing
+}
+
+// CloseIf registers a condition checked when the headers are written,
+// e.g. a server that started shutting down while the handler ran
+func (w *Writer) CloseIf(cond func() bool) {
+	w.closeIf = cond
+}
+
+// Status returns the status code written so far, 0 if none
+func (w *Writer) Status() StatusCode {
+	return w.status
+}
+
+// BytesWritten returns the number of body bytes written so far
+func (w *Writer) BytesWritten() int64 {
+	return w.bytesWritten
+}
+
+// AddHeader queues a field for the next WriteHeaders call. Fields the
+// handler sets itself win, so middleware can add defaults up front.
+func (w *Writer) AddHeader(key, value string) {
+	if w.extra == nil {
+		w.extra = headers.NewHeaders()
+	}
+	w.extra.Set(key, value)
+}
+
+// WriteStatusLine sets the final status line with the registered reason
+// phrase, codes outside the registry get an empty one. For 1xx see
+// WriteInformational. The line is sent along with the headers, until
+// then another call replaces it.
+func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	return w.WriteStatusLineReason(statusCode, StatusText(statusCode))
 }
  
+// WriteHeaders writes the status line, the fields and the empty line ending
+// them. A field with an invalid name or value (e.g. a CR or LF in it) fails
+// with ERROR_INVALID_FIELD before anything is sent, the response can still
+// be started over with WriteStatusLine.
 func (w *Writer) WriteHeaders(headers headers.Headers) error {
-	b := []byte{} 
+	if w.state != StateHeaders {
+		return w.outOfOrder("WriteHeaders")
+	}
+	if err := checkFields(&headers, w.extra); err != nil {
+		return err
+	}
+	next, length, err := w.framing(headers)
+	if err != nil {
+		return err
+	}
+	w.state, w.remaining = next, length
+	if next == StateChunked {
+		w.declared = trailerNames(headers.Get("trailer"))
+	}
+	if next == StateBodyEOF && !w.head {
+		// the client only knows the body ended when we hang up
+		w.keepAlive = false
+	}
+
+	// 1xx, 204 and 304 have no body to frame (RFC 9110 8.6, 15.3.5, 15.4.5)
+	noBody := !BodyAllowed(w.status)
+	b := w.statusLine
+	w.statusLine = nil
 
 	headers.ForEach(func(key, val string){
-		b = fmt.Appendf(b, "%s: %s\r\n", key, val)
+		if noBody && isFraming(key) {
+			return
+		}
+		b = w.appendField(b, key, val)
 	})
+	if w.extra != nil {
+		w.extra.ForEach(func(key, val string) {
+			if !headers.Has(key) && !(noBody && isFraming(key)) {
+				b = w.appendField(b, key, val)
+			}
+		})
+	}
+
+	if w.closeIf != nil && w.closeIf() {
+		w.keepAlive = false
+	}
+	if headers.HasToken("connection", "close") {
+		w.keepAlive = false
+	} else if !w.keepAlive {
+		b = w.appendField(b, "Connection", "close")
+	}
 
 	b = fmt.Appendf(b, "\r\n")
-	_, err := w.writer.Write(b)
+	_, err = w.writer.Write(b)
 
 	return err  
 }
 
+// WriteBody writes to a body framed by Content-Length or by closing
+// the connection. Bytes beyond the Content-Length are not sent.
 func (w *Writer) WriteBody(p []byte) (int, error) {
-	n, err := w.writer.Write(p)
-	return n, err 
+	if w.state != StateBody && w.state != StateBodyEOF {
+		return 0, w.bodyError("WriteBody")
+	}
+
+	var tooLong error
+	if w.state == StateBody && int64(len(p)) > w.remaining {
+		p = p[:w.remaining]
+		tooLong = ERROR_BODY_TOO_LONG
+	}
+
+	n, err := w.write(p)
+	if w.state == StateBody {
+		w.remaining -= int64(n)
+		if w.remaining == 0 {
+			w.state = StateDone
+		}
+	}
+	if err != nil {
+		return n, err
+	}
+	return n, tooLong
+}
+
+// ReadFrom writes the body from r, like WriteBody without holding it in
+// memory. On a TCP connection an *os.File goes out with sendfile, no copy
+// through user space. A Content-Length body reads at most that many bytes,
+// ERROR_BODY_TOO_LONG if r is a file or in-memory reader with more left;
+// other readers are not probed, a read could block. For HEAD nothing is
+// read from r, the body counts as sent.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if w.state != StateBody && w.state != StateBodyEOF {
+		return 0, w.bodyError("ReadFrom")
+	}
+	if w.head {
+		if w.state == StateBody {
+			w.remaining = 0
+			w.state = StateDone
+		}
+		return 0, nil
+	}
+	if w.state == StateBodyEOF {
+		return w.copy(r)
+	}
+
+	// sendfile sees through one LimitedReader only, io.CopyN already made one
+	src := r
+	if lr, ok := r.(*io.LimitedReader); !ok || lr.N > w.remaining {
+		src = io.LimitReader(r, w.remaining)
+	}
+	n, err := w.copy(src)
+	w.remaining -= n
+	if w.remaining > 0 || err != nil {
+		return n, err
+	}
+	w.state = StateDone
+
+	if hasMore(r) {
+		return n, ERROR_BODY_TOO_LONG
+	}
+	return n, nil
+}
+
+// reports whether r has data left, for readers that can tell without
+// a read that might block: regular files and in-memory readers
+func hasMore(r io.Reader) bool {
+	switch r := r.(type) {
+	case *io.LimitedReader:
+		return r.N > 0 && hasMore(r.R)
+	case interface{ Len() int }:
+		// bytes.Reader, strings.Reader, bytes.Buffer
+		return r.Len() > 0
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return false
+		}
+		offset, err := r.Seek(0, io.SeekCurrent)
+		return err == nil && offset < info.Size()
+	}
+	return false
+}
+
+// one field line, the name cased as set with SetHeaderCasing
+func (w *Writer) appendField(b []byte, key, val string) []byte {
+	return fmt.Appendf(b, "%s: %s\r\n", w.casing.Format(key), val)
 }
 
 // transfer-encoding
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if w.state != StateChunked {
+		return 0, w.bodyError("WriteChunkedBody")
+	}
 	if len(p) == 0 {
 		return 0, nil
 	}
 
 	// chunk-size
-	if _, err := fmt.Fprintf(w.writer, "%x\r\n", len(p)); err != nil {
+	if _, err := fmt.Fprintf(w.body(), "%x\r\n", len(p)); err != nil {
 		return 0, err
 	}
 
 	// chunk-data
-	if _, err := w.writer.Write(p); err != nil {
+	if _, err := w.write(p); err != nil {
 		return 0, err
 	}
 
 	// CRLF
-	if _, err := w.writer.Write([]byte("\r\n")); err != nil {
+	if _, err := w.body().Write([]byte("\r\n")); err != nil {
 		return 0, err
 	}
 
@@ -97,20 +310,36 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 }
 
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
+	if w.state != StateChunked {
+		return 0, w.outOfOrder("WriteChunkedBodyDone")
+	}
+	w.state = StateTrailers
+
 	// final chunk
-	_, err := w.writer.Write([]byte("0\r\n"))
+	_, err := w.body().Write([]byte("0\r\n"))
 	return 0, err
 }
 
-// add trailer header
-func (w *Writer) WriteTrailers(t *headers.Headers, body []byte) error {
-	// write trailer headers
+// WriteTrailers ends a chunked body after WriteChunkedBodyDone. Every
+// field has to be declared in the Trailer header and allowed in trailers,
+// otherwise nothing is written and the trailers can be sent again.
+func (w *Writer) WriteTrailers(t *headers.Headers) error {
+	if w.state != StateTrailers {
+		return w.outOfOrder("WriteTrailers")
+	}
+	if err := w.checkTrailers(t); err != nil {
+		return err
+	}
+	w.state = StateDone
+
+	b := []byte{}
 	t.ForEach(func(k, v string) {
-		fmt.Fprintf(w.writer, "%s: %s\r\n", k, v)
+		b = w.appendField(b, k, v)
 	})
 
 	// end of trailers
-	_, err := w.writer.Write([]byte("\r\n"))
+	b = fmt.Appendf(b, "\r\n")
+	_, err := w.body().Write(b)
 	return err
 }
 
@@ -0,0 +1,818 @@
+package response
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kalim-Asim/http-server/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterState(t *testing.T) {
+	t.Run("Fixed length", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		assert.Equal(t, StateStatus, w.State())
+
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		assert.Equal(t, StateHeaders, w.State())
+		require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(5)))
+		assert.Equal(t, StateBody, w.State())
+		assert.False(t, w.Complete())
+
+		_, err := w.WriteBody([]byte("hel"))
+		require.NoError(t, err)
+		_, err = w.WriteBody([]byte("lo"))
+		require.NoError(t, err)
+		assert.Equal(t, StateDone, w.State())
+		assert.True(t, w.Complete())
+		assert.True(t, w.KeepAlive())
+	})
+
+	t.Run("Body longer than Content-Length", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.WriteStatusLine(StatusOK)
+		w.WriteHeaders(*GetDefaultHeaders(2))
+
+		n, err := w.WriteBody([]byte("hello"))
+		assert.ErrorIs(t, err, ERROR_BODY_TOO_LONG)
+		assert.Equal(t, 2, n)
+		assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\nhe")))
+	})
+
+	t.Run("Chunked with trailers", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+
+		w.WriteStatusLine(StatusOK)
+		require.NoError(t, w.WriteHeaders(*h))
+		assert.Equal(t, StateChunked, w.State())
+
+		_, err := w.WriteBody([]byte("x"))
+		assert.ErrorIs(t, err, ERROR_OUT_OF_ORDER)
+
+		_, err = w.WriteChunkedBody([]byte("abc"))
+		require.NoError(t, err)
+		_, err = w.WriteChunkedBodyDone()
+		require.NoError(t, err)
+		assert.Equal(t, StateTrailers, w.State())
+		assert.False(t, w.Complete())
+
+		require.NoError(t, w.WriteTrailers(headers.NewHeaders()))
+		assert.True(t, w.Complete())
+		assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("3\r\nabc\r\n0\r\n\r\n")))
+	})
+
+	t.Run("Until close", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.WriteStatusLine(StatusOK)
+		require.NoError(t, w.WriteHeaders(*headers.NewHeaders()))
+
+		assert.Equal(t, StateBodyEOF, w.State())
+		assert.True(t, w.Complete())
+		assert.False(t, w.KeepAlive())
+		assert.Contains(t, buf.String(), "Connection: close\r\n")
+
+		_, err := w.WriteBody([]byte("as much as we like"))
+		assert.NoError(t, err)
+	})
+
+	t.Run("No body", func(t *testing.T) {
+		w := NewWriter(&bytes.Buffer{})
+		w.WriteStatusLine(204)
+		require.NoError(t, w.WriteHeaders(*headers.NewHeaders()))
+		assert.Equal(t, StateDone, w.State())
+		assert.True(t, w.KeepAlive())
+	})
+
+	t.Run("Out of order", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+
+		_, err := w.WriteBody([]byte("x"))
+		assert.ErrorIs(t, err, ERROR_OUT_OF_ORDER)
+		assert.EqualError(t, err, "response written out of order: WriteBody during status line")
+		assert.ErrorIs(t, w.WriteHeaders(*GetDefaultHeaders(0)), ERROR_OUT_OF_ORDER)
+		assert.Zero(t, buf.Len())
+
+		w.WriteStatusLine(StatusOK)
+		w.WriteHeaders(*GetDefaultHeaders(0))
+		assert.ErrorIs(t, w.WriteStatusLine(StatusOK), ERROR_OUT_OF_ORDER)
+		assert.ErrorIs(t, w.WriteHeaders(*GetDefaultHeaders(0)), ERROR_OUT_OF_ORDER)
+		_, err = w.WriteChunkedBody([]byte("x"))
+		assert.ErrorIs(t, err, ERROR_OUT_OF_ORDER)
+	})
+
+	t.Run("Bad framing", func(t *testing.T) {
+		w := NewWriter(&bytes.Buffer{})
+		h := GetDefaultHeaders(3)
+		h.Set("Transfer-Encoding", "chunked")
+		w.WriteStatusLine(StatusOK)
+		assert.ErrorIs(t, w.WriteHeaders(*h), ERROR_BAD_FRAMING)
+
+		for _, cl := range []string{"-1", "+5", " 5x", "5, 6"} {
+			h = GetDefaultHeaders(0)
+			h.Set("Content-Length", cl)
+			assert.ErrorIs(t, w.WriteHeaders(*h), ERROR_BAD_FRAMING, cl)
+		}
+		assert.Equal(t, StateHeaders, w.State())
+
+		// the same length repeated is one length
+		h = GetDefaultHeaders(0)
+		h.Set("Content-Length", "2")
+		h.Add("Content-Length", "2")
+		require.NoError(t, w.WriteHeaders(*h))
+		assert.Equal(t, StateBody, w.State())
+	})
+}
+
+func TestAutoWriter(t *testing.T) {
+	t.Run("Small body gets a Content-Length", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		aw := NewAutoWriter(w)
+		aw.Header().Set("X-Test", "1")
+		aw.WriteHeader(StatusNotFound)
+		aw.WriteHeader(StatusOK) // ignored
+		aw.Write([]byte("<html>"))
+		aw.Write([]byte("</html>"))
+		assert.Zero(t, buf.Len(), "nothing sent before Close")
+
+		require.NoError(t, aw.Close())
+		res := buf.String()
+		assert.Contains(t, res, "HTTP/1.1 404 Not Found\r\n")
+		assert.Contains(t, res, "Content-Length: 13\r\n")
+		assert.Contains(t, res, "Content-Type: text/html; charset=utf-8\r\n")
+		assert.Contains(t, res, "X-Test: 1\r\n")
+		assert.True(t, strings.HasSuffix(res, "\r\n\r\n<html></html>"))
+		assert.Equal(t, StateDone, w.State())
+	})
+
+	t.Run("Large body is chunked", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		aw := NewAutoWriterSize(w, 4)
+		aw.Write([]byte("abc"))
+		aw.Write([]byte("defg"))
+		assert.Equal(t, StateChunked, w.State())
+		require.NoError(t, aw.Close())
+
+		res := buf.String()
+		assert.Contains(t, res, "HTTP/1.1 200 OK\r\n")
+		assert.Contains(t, res, "Transfer-Encoding: chunked\r\n")
+		assert.NotContains(t, res, "content-length")
+		assert.True(t, strings.HasSuffix(res, "\r\n\r\n3\r\nabc\r\n4\r\ndefg\r\n0\r\n\r\n"))
+		assert.True(t, w.Complete())
+	})
+
+	t.Run("First write larger than the buffer is sniffed", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		aw := NewAutoWriterSize(w, 16)
+		aw.Write([]byte("<html><body>" + strings.Repeat("x", 100) + "</body></html>"))
+		require.NoError(t, aw.Close())
+
+		res := buf.String()
+		assert.Contains(t, res, "Transfer-Encoding: chunked\r\n")
+		assert.Contains(t, res, "Content-Type: text/html; charset=utf-8\r\n")
+	})
+
+	t.Run("Short buffer is topped up for sniffing", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		aw := NewAutoWriterSize(w, 16)
+		aw.Write([]byte("\x89PNG"))
+		aw.Write([]byte("\r\n\x1a\n" + strings.Repeat("\x00", 100)))
+		require.NoError(t, aw.Close())
+
+		assert.Contains(t, buf.String(), "Content-Type: image/png\r\n")
+	})
+
+	t.Run("Explicit Content-Length is kept", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		aw := NewAutoWriterSize(w, 1)
+		aw.Header().Set("Content-Length", "6")
+		aw.Write([]byte("abc"))
+		aw.Write([]byte("def"))
+		require.NoError(t, aw.Close())
+
+		res := buf.String()
+		assert.Contains(t, res, "Content-Length: 6\r\n")
+		assert.Contains(t, res, "Content-Type: text/plain; charset=utf-8\r\n")
+		assert.True(t, strings.HasSuffix(res, "\r\n\r\nabcdef"))
+		assert.Equal(t, StateDone, w.State())
+	})
+
+	t.Run("Flush starts a chunked body", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		aw := NewAutoWriter(w)
+		aw.Write([]byte("first"))
+		require.NoError(t, aw.Flush())
+		assert.Contains(t, buf.String(), "5\r\nfirst\r\n")
+		require.NoError(t, aw.Close())
+	})
+
+	t.Run("Empty and bodiless responses", func(t *testing.T) {
+		var buf bytes.Buffer
+		aw := NewAutoWriter(NewWriter(&buf))
+		require.NoError(t, aw.Close())
+		assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", buf.String())
+
+		buf.Reset()
+		aw = NewAutoWriter(NewWriter(&buf))
+		aw.WriteHeader(204)
+		require.NoError(t, aw.Close())
+		assert.Equal(t, "HTTP/1.1 204 No Content\r\n\r\n", buf.String())
+	})
+}
+
+// the status line write sends, once the headers follow
+func sentStatusLine(t *testing.T, write func(w *Writer) error) string {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, write(w))
+	assert.Zero(t, buf.Len(), "held until the headers")
+
+	h := headers.NewHeaders()
+	h.Set("Content-Length", "0")
+	require.NoError(t, w.WriteHeaders(*h))
+	line, _, _ := strings.Cut(buf.String(), "Content-Length")
+	return line
+}
+
+func TestStatusLine(t *testing.T) {
+	t.Run("Registered codes", func(t *testing.T) {
+		line := sentStatusLine(t, func(w *Writer) error {
+			return w.WriteStatusLine(StatusTooManyRequests)
+		})
+		assert.Equal(t, "HTTP/1.1 429 Too Many Requests\r\n", line)
+
+		assert.Equal(t, "Range Not Satisfiable", StatusText(416))
+		assert.Equal(t, "Early Hints", StatusText(StatusEarlyHints))
+		assert.Empty(t, StatusText(599))
+	})
+
+	t.Run("Unknown codes have an empty reason", func(t *testing.T) {
+		line := sentStatusLine(t, func(w *Writer) error {
+			return w.WriteStatusLine(599)
+		})
+		assert.Equal(t, "HTTP/1.1 599 \r\n", line)
+	})
+
+	t.Run("Custom reason", func(t *testing.T) {
+		line := sentStatusLine(t, func(w *Writer) error {
+			return w.WriteStatusLineReason(StatusOK, "Totally Fine")
+		})
+		assert.Equal(t, "HTTP/1.1 200 Totally Fine\r\n", line)
+	})
+
+	t.Run("Replaced until the headers are sent", func(t *testing.T) {
+		line := sentStatusLine(t, func(w *Writer) error {
+			w.WriteStatusLine(StatusOK)
+			return w.WriteStatusLine(StatusNotFound)
+		})
+		assert.Equal(t, "HTTP/1.1 404 Not Found\r\n", line)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		assert.ErrorIs(t, w.WriteStatusLine(99), ERROR_BAD_STATUS)
+		assert.ErrorIs(t, w.WriteStatusLine(600), ERROR_BAD_STATUS)
+		assert.ErrorIs(t, w.WriteStatusLine(StatusContinue), ERROR_BAD_STATUS)
+		assert.ErrorIs(t, w.WriteStatusLineReason(StatusOK, "OK\r\nX-Injected: 1"), ERROR_BAD_STATUS)
+		assert.Zero(t, buf.Len())
+		assert.Equal(t, StateStatus, w.State())
+	})
+
+	t.Run("Informational before the final response", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		hints := headers.NewHeaders()
+		hints.Set("Link", "</style.css>; rel=preload; as=style")
+
+		require.NoError(t, w.WriteInformational(StatusContinue, nil))
+		require.NoError(t, w.WriteInformational(StatusEarlyHints, hints))
+		assert.ErrorIs(t, w.WriteInformational(StatusSwitchingProtocols, nil), ERROR_BAD_STATUS)
+		assert.ErrorIs(t, w.WriteInformational(StatusOK, nil), ERROR_BAD_STATUS)
+		assert.Equal(t, StateStatus, w.State())
+		assert.Zero(t, w.Status())
+
+		final := headers.NewHeaders()
+		final.Set("Content-Length", "0")
+		w.WriteStatusLine(StatusOK)
+		w.WriteHeaders(*final)
+		assert.ErrorIs(t, w.WriteInformational(StatusContinue, nil), ERROR_OUT_OF_ORDER)
+
+		assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\n"+
+			"HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload; as=style\r\n\r\n"+
+			"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", buf.String())
+	})
+
+	t.Run("Informational leaves out framing fields", func(t *testing.T) {
+		var buf bytes.Buffer
+		aw := NewAutoWriter(NewWriter(&buf))
+		aw.Header().Set("Link", "</style.css>; rel=preload")
+		aw.Header().Set("Content-Length", "5")
+		aw.Header().Set("Trailer", "X-Sum")
+		aw.WriteHeader(StatusEarlyHints)
+
+		assert.Equal(t, "HTTP/1.1 103 Early Hints\r\n"+
+			"Link: </style.css>; rel=preload\r\n\r\n", buf.String())
+	})
+}
+
+// a writer with chunked headers written, declaring trailers
+func chunkedWriter(t *testing.T, buf *bytes.Buffer, trailers string) *Writer {
+	t.Helper()
+	w := NewWriter(buf)
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	if trailers != "" {
+		h.Set("Trailer", trailers)
+	}
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(*h))
+	buf.Reset()
+	return w
+}
+
+func TestChunkedWriter(t *testing.T) {
+	t.Run("Small writes are gathered", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := chunkedWriter(t, &buf, "X-Sum")
+		cw := NewChunkedWriterSize(w, 4)
+
+		for _, s := range []string{"a", "b", "c", "d", "e", "fghij"} {
+			n, err := cw.Write([]byte(s))
+			require.NoError(t, err)
+			assert.Equal(t, len(s), n)
+		}
+		cw.Trailer().Set("X-Sum", "42")
+		require.NoError(t, cw.Close())
+
+		assert.Equal(t, "4\r\nabcd\r\n1\r\ne\r\n5\r\nfghij\r\n0\r\nX-Sum: 42\r\n\r\n", buf.String())
+		assert.True(t, w.Complete())
+
+		_, err := cw.Write([]byte("late"))
+		assert.ErrorIs(t, err, ERROR_OUT_OF_ORDER)
+	})
+
+	t.Run("ReadFrom", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := chunkedWriter(t, &buf, "")
+		cw := NewChunkedWriterSize(w, 4)
+
+		var _ io.ReaderFrom = cw
+		n, err := cw.ReadFrom(strings.NewReader("0123456789"))
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), n)
+		require.NoError(t, cw.Close())
+
+		assert.Equal(t, "4\r\n0123\r\n4\r\n4567\r\n2\r\n89\r\n0\r\n\r\n", buf.String())
+		assert.Equal(t, int64(10), w.BytesWritten())
+	})
+
+	t.Run("No size gets the default", func(t *testing.T) {
+		var buf bytes.Buffer
+		cw := NewChunkedWriterSize(chunkedWriter(t, &buf, ""), 0)
+		n, err := cw.ReadFrom(strings.NewReader("abc"))
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), n)
+		require.NoError(t, cw.Close())
+		assert.Equal(t, "3\r\nabc\r\n0\r\n\r\n", buf.String())
+	})
+
+	t.Run("Trailers must be declared and allowed", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := chunkedWriter(t, &buf, "X-Sum, Content-Length")
+		cw := NewChunkedWriter(w)
+		cw.Write([]byte("body"))
+
+		cw.Trailer().Set("X-Other", "1")
+		assert.ErrorIs(t, cw.Close(), ERROR_UNDECLARED_TRAILER)
+		cw.Trailer().Delete("X-Other")
+
+		cw.Trailer().Set("Content-Length", "4")
+		assert.ErrorIs(t, cw.Close(), ERROR_FORBIDDEN_TRAILER)
+		assert.Zero(t, buf.Len(), "nothing sent on a rejected Close")
+		cw.Trailer().Delete("Content-Length")
+
+		cw.Trailer().Set("x-sum", "1")
+		require.NoError(t, cw.Close())
+		assert.Equal(t, "4\r\nbody\r\n0\r\nX-Sum: 1\r\n\r\n", buf.String())
+	})
+
+	t.Run("WriteTrailers checks too", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := chunkedWriter(t, &buf, "")
+		w.WriteChunkedBodyDone()
+
+		bad := headers.NewHeaders()
+		bad.Set("Host", "example.com")
+		assert.ErrorIs(t, w.WriteTrailers(bad), ERROR_FORBIDDEN_TRAILER)
+		assert.Equal(t, StateTrailers, w.State())
+
+		require.NoError(t, w.WriteTrailers(headers.NewHeaders()))
+		assert.Equal(t, "0\r\n\r\n", buf.String())
+	})
+}
+
+func TestHEAD(t *testing.T) {
+	t.Run("Fixed length", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.SetMethod("HEAD")
+		h := headers.NewHeaders()
+		h.Set("Content-Length", "5")
+		w.WriteStatusLine(StatusOK)
+		w.WriteHeaders(*h)
+
+		n, err := w.WriteBody([]byte("hello"))
+		require.NoError(t, err)
+		assert.Equal(t, 5, n)
+		assert.True(t, w.Complete())
+		assert.Zero(t, w.BytesWritten())
+		assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n", buf.String())
+	})
+
+	t.Run("Chunked with trailers", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.SetMethod("HEAD")
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "X-Sum")
+		w.WriteStatusLine(StatusOK)
+		w.WriteHeaders(*h)
+		headerLen := buf.Len()
+
+		cw := NewChunkedWriter(w)
+		cw.Write([]byte("body"))
+		cw.Trailer().Set("X-Sum", "1")
+		require.NoError(t, cw.Close())
+
+		assert.Equal(t, headerLen, buf.Len())
+		assert.True(t, w.Complete())
+		assert.True(t, w.KeepAlive())
+	})
+
+	t.Run("AutoWriter gets the GET Content-Length", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.SetMethod("HEAD")
+		aw := NewAutoWriter(w)
+		aw.Write([]byte("hello"))
+		require.NoError(t, aw.Close())
+
+		assert.Contains(t, buf.String(), "Content-Length: 5\r\n")
+		assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+	})
+}
+
+func TestNoBodyStatus(t *testing.T) {
+	for _, code := range []StatusCode{StatusNoContent, StatusNotModified} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.AddHeader("Content-Length", "3")
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("ETag", `"v1"`)
+
+		w.WriteStatusLine(code)
+		require.NoError(t, w.WriteHeaders(*h))
+		assert.Equal(t, fmt.Sprintf("HTTP/1.1 %d %s\r\nETag: \"v1\"\r\n\r\n", code, StatusText(code)), buf.String())
+		assert.True(t, w.Complete())
+
+		_, err := w.WriteBody([]byte("abc"))
+		assert.ErrorIs(t, err, ERROR_BODY_NOT_ALLOWED)
+		_, err = w.WriteChunkedBody([]byte("abc"))
+		assert.ErrorIs(t, err, ERROR_BODY_NOT_ALLOWED)
+
+		aw := NewAutoWriter(NewWriter(&bytes.Buffer{}))
+		aw.WriteHeader(code)
+		_, err = aw.Write([]byte("abc"))
+		assert.ErrorIs(t, err, ERROR_BODY_NOT_ALLOWED)
+	}
+}
+
+func TestReadFrom(t *testing.T) {
+	fixed := func(buf *bytes.Buffer, length int) *Writer {
+		w := NewWriter(buf)
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(length)))
+		buf.Reset()
+		return w
+	}
+
+	t.Run("Fixed length", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := fixed(&buf, 5)
+		n, err := w.ReadFrom(strings.NewReader("hello"))
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), n)
+		assert.Equal(t, "hello", buf.String())
+		assert.Equal(t, StateDone, w.State())
+		assert.Equal(t, int64(5), w.BytesWritten())
+	})
+
+	t.Run("Too long", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := fixed(&buf, 3)
+		n, err := w.ReadFrom(strings.NewReader("hello"))
+		assert.ErrorIs(t, err, ERROR_BODY_TOO_LONG)
+		assert.Equal(t, int64(3), n)
+		assert.Equal(t, "hel", buf.String())
+	})
+
+	t.Run("In parts", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := fixed(&buf, 10)
+		_, err := w.ReadFrom(strings.NewReader("hello"))
+		require.NoError(t, err)
+		assert.Equal(t, StateBody, w.State())
+		_, err = w.ReadFrom(io.LimitReader(strings.NewReader("worlds"), 5))
+		require.NoError(t, err)
+		assert.Equal(t, "helloworld", buf.String())
+		assert.True(t, w.Complete())
+	})
+
+	t.Run("Too long file", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "body")
+		require.NoError(t, os.WriteFile(name, []byte("hello"), 0o644))
+		f, err := os.Open(name)
+		require.NoError(t, err)
+		defer f.Close()
+
+		var buf bytes.Buffer
+		w := fixed(&buf, 3)
+		_, err = w.ReadFrom(f)
+		assert.ErrorIs(t, err, ERROR_BODY_TOO_LONG)
+
+		buf.Reset()
+		f.Seek(0, io.SeekStart)
+		w = fixed(&buf, 5)
+		_, err = w.ReadFrom(f)
+		require.NoError(t, err)
+	})
+
+	t.Run("Stream left open", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		defer pw.Close()
+		go pw.Write([]byte("hello"))
+
+		var buf bytes.Buffer
+		w := fixed(&buf, 5)
+		done := make(chan error)
+		go func() {
+			_, err := w.ReadFrom(pr)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(2 * time.Second):
+			t.Fatal("ReadFrom waits for more of a complete body")
+		}
+		assert.Equal(t, "hello", buf.String())
+	})
+
+	t.Run("Until close", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.NoError(t, w.WriteHeaders(*headers.NewHeaders()))
+		buf.Reset()
+		_, err := w.ReadFrom(strings.NewReader("all of it"))
+		require.NoError(t, err)
+		assert.Equal(t, "all of it", buf.String())
+	})
+
+	t.Run("Out of order", func(t *testing.T) {
+		var buf bytes.Buffer
+		_, err := NewWriter(&buf).ReadFrom(strings.NewReader("x"))
+		assert.ErrorIs(t, err, ERROR_OUT_OF_ORDER)
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("HEAD", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.SetMethod("HEAD")
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(5)))
+		head := buf.String()
+		r := strings.NewReader("hello")
+		_, err := w.ReadFrom(r)
+		require.NoError(t, err)
+		assert.Equal(t, head, buf.String())
+		assert.Equal(t, 5, r.Len(), "nothing read for HEAD")
+		assert.Equal(t, StateDone, w.State())
+	})
+
+	t.Run("AutoWriter", func(t *testing.T) {
+		var buf bytes.Buffer
+		aw := NewAutoWriter(NewWriter(&buf))
+		aw.Header().Set("Content-Type", "text/plain")
+		aw.Header().Set("Content-Length", "5")
+		_, err := io.Copy(aw, strings.NewReader("hello"))
+		require.NoError(t, err)
+		require.NoError(t, aw.Close())
+		assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nhello"))
+		assert.NotContains(t, buf.String(), "transfer-encoding")
+
+		// no length known, buffered as usual
+		buf.Reset()
+		aw = NewAutoWriter(NewWriter(&buf))
+		_, err = io.Copy(aw, strings.NewReader("<html>hi</html>"))
+		require.NoError(t, err)
+		require.NoError(t, aw.Close())
+		assert.Contains(t, buf.String(), "Content-Length: 15\r\n")
+		assert.Contains(t, buf.String(), "Content-Type: text/html; charset=utf-8\r\n")
+	})
+}
+
+func TestHeaderOrder(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.AddHeader("Server", "tcp-to-http")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+
+	h := headers.NewHeaders()
+	h.Add("Set-Cookie", "a=1; Path=/")
+	h.Set("Content-Type", "text/plain")
+	h.Add("Set-Cookie", "b=2; Path=/")
+	h.Set("Content-Length", "2")
+	require.NoError(t, w.WriteHeaders(*h))
+	_, err := w.WriteBody([]byte("ok"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Set-Cookie: a=1; Path=/\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Set-Cookie: b=2; Path=/\r\n"+
+		"Content-Length: 2\r\n"+
+		"Server: tcp-to-http\r\n"+
+		"\r\nok", buf.String())
+}
+
+func TestHeaderCasing(t *testing.T) {
+	for casing, want := range map[headers.Casing]string{
+		headers.CaseCanonical: "Content-Length: 0\r\nWWW-Authenticate: Basic\r\nX-Custom-Id: 1\r\nConnection: close\r\n",
+		headers.CasePreserve:  "content-length: 0\r\nwww-authenticate: Basic\r\nX-CUSTOM-id: 1\r\nConnection: close\r\n",
+		headers.CaseLower:     "content-length: 0\r\nwww-authenticate: Basic\r\nx-custom-id: 1\r\nconnection: close\r\n",
+	} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.SetHeaderCasing(casing)
+		w.SetKeepAlive(false)
+		require.NoError(t, w.WriteStatusLine(StatusUnauthorized))
+
+		h := headers.NewHeaders()
+		h.Set("content-length", "0")
+		h.Set("www-authenticate", "Basic")
+		h.Set("X-CUSTOM-id", "1")
+		require.NoError(t, w.WriteHeaders(*h))
+
+		assert.Equal(t, "HTTP/1.1 401 Unauthorized\r\n"+want+"\r\n", buf.String(), casing.String())
+	}
+}
+
+func TestInvalidFields(t *testing.T) {
+	t.Run("Headers", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		require.NoError(t, w.WriteStatusLine(StatusFound))
+
+		h := headers.NewHeaders()
+		h.Add("Location", "/next\r\nSet-Cookie: admin=1")
+		h.Set("Content-Length", "0")
+		err := w.WriteHeaders(*h)
+		assert.ErrorIs(t, err, ERROR_INVALID_FIELD)
+		assert.ErrorIs(t, err, headers.ERROR_INVALID_FIELD_VALUE)
+		assert.Zero(t, buf.Len(), "nothing written, not even the status line")
+		assert.Equal(t, StateHeaders, w.State())
+
+		// still time for another answer
+		require.NoError(t, w.WriteStatusLine(StatusInternalServerError))
+		require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(0)))
+		assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 500 Internal Server Error\r\n"))
+		assert.True(t, w.Complete())
+	})
+
+	t.Run("Dropped by Set", func(t *testing.T) {
+		var buf bytes.Buffer
+		aw := NewAutoWriter(NewWriter(&buf))
+		aw.Header().Set("Location", "/next\r\nSet-Cookie: admin=1")
+		aw.WriteHeader(StatusFound)
+		require.NoError(t, aw.Close())
+		assert.Equal(t, "HTTP/1.1 302 Found\r\nContent-Length: 0\r\n\r\n", buf.String())
+	})
+
+	t.Run("Queued with AddHeader", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.AddHeader("X-Bad", "a\x00b")
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(0)))
+		assert.NotContains(t, buf.String(), "X-Bad")
+	})
+
+	t.Run("Trailers", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := chunkedWriter(t, &buf, "X-Sum")
+		_, err := w.WriteChunkedBodyDone()
+		require.NoError(t, err)
+
+		tr := headers.NewHeaders()
+		tr.Add("X-Sum", "1\n2")
+		assert.ErrorIs(t, w.WriteTrailers(tr), ERROR_INVALID_FIELD)
+		assert.Equal(t, StateTrailers, w.State())
+	})
+
+	t.Run("Informational", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		h := headers.NewHeaders()
+		h.Add("Link", "</a.css>\r\n\r\n")
+		assert.ErrorIs(t, w.WriteInformational(StatusEarlyHints, h), ERROR_INVALID_FIELD)
+		assert.Empty(t, buf.String())
+	})
+}
+
+// a TCP connection whose peer reads and drops everything
+func discardConn(b *testing.B) net.Conn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(b, err)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(io.Discard, c)
+		c.Close()
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(b, err)
+	b.Cleanup(func() {
+		conn.Close()
+		ln.Close()
+	})
+	return conn
+}
+
+func fileResponse(b *testing.B, conn net.Conn, size int) *Writer {
+	w := NewWriter(conn)
+	require.NoError(b, w.WriteStatusLine(StatusOK))
+	require.NoError(b, w.WriteHeaders(*GetDefaultHeaders(size)))
+	return w
+}
+
+// the whole file read into memory and written at once,
+// against handing the *os.File to the connection
+func BenchmarkFileBody(b *testing.B) {
+	for _, size := range []int{64 << 10, 8 << 20} {
+		name := filepath.Join(b.TempDir(), "body")
+		require.NoError(b, os.WriteFile(name, bytes.Repeat([]byte("x"), size), 0o644))
+		conn := discardConn(b)
+
+		b.Run(fmt.Sprintf("WriteBody/%dKiB", size>>10), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for b.Loop() {
+				data, err := os.ReadFile(name)
+				require.NoError(b, err)
+				if _, err := fileResponse(b, conn, size).WriteBody(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("ReadFrom/%dKiB", size>>10), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for b.Loop() {
+				f, err := os.Open(name)
+				require.NoError(b, err)
+				if _, err := fileResponse(b, conn, size).ReadFrom(f); err != nil {
+					b.Fatal(err)
+				}
+				f.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/kalim-Asim/http-server/internal/headers"
+)
+
+// WriterState tells how far a response got. A response moves through
+// status line, headers and a body in one of the modes below, a chunked
+// body then goes through its trailers; only forward moves are allowed.
+type WriterState int
+
+const (
+	StateStatus   WriterState = iota // nothing written yet
+	StateHeaders                     // status line held, sent with the headers
+	StateBody                        // body with a Content-Length
+	StateBodyEOF                     // body delimited by closing the connection
+	StateChunked                     // chunked body, WriteChunkedBodyDone ends it
+	StateTrailers                    // last chunk written, trailers next
+	StateDone                        // response complete
+)
+
+func (s WriterState) String() string {
+	switch s {
+	case StateStatus:
+		return "status line"
+	case StateHeaders:
+		return "headers"
+	case StateBody:
+		return "fixed-length body"
+	case StateBodyEOF:
+		return "body until close"
+	case StateChunked:
+		return "chunked body"
+	case StateTrailers:
+		return "trailers"
+	case StateDone:
+		return "done"
+	}
+	return fmt.Sprintf("WriterState(%d)", int(s))
+}
+
+var ERROR_OUT_OF_ORDER = errors.New("response written out of order")
+var ERROR_BODY_TOO_LONG = errors.New("body longer than the declared Content-Length")
+var ERROR_BAD_FRAMING = errors.New("invalid response framing headers")
+var ERROR_BODY_NOT_ALLOWED = errors.New("response status does not allow a body")
+var ERROR_INVALID_FIELD = errors.New("invalid header field")
+
+// wraps ERROR_OUT_OF_ORDER with what was called and where the response is
+func (w *Writer) outOfOrder(call string) error {
+	return fmt.Errorf("%w: %s during %s", ERROR_OUT_OF_ORDER, call, w.state)
+}
+
+// a body write in the wrong state, telling a status that never has
+// a body apart from a call out of order
+func (w *Writer) bodyError(call string) error {
+	if w.state == StateDone && !BodyAllowed(w.status) {
+		return fmt.Errorf("%w: %s for %d", ERROR_BODY_NOT_ALLOWED, call, w.status)
+	}
+	return w.outOfOrder(call)
+}
+
+// where body bytes go, nowhere for HEAD
+func (w *Writer) body() io.Writer {
+	if w.head {
+		return io.Discard
+	}
+	return w.writer
+}
+
+// writes body data, counting what was sent
+func (w *Writer) write(p []byte) (int, error) {
+	if w.head {
+		return len(p), nil
+	}
+	n, err := w.writer.Write(p)
+	w.bytesWritten += int64(n)
+	return n, err
+}
+
+// copies body data from r, counting what was sent
+func (w *Writer) copy(r io.Reader) (int64, error) {
+	n, err := io.Copy(w.writer, r)
+	w.bytesWritten += n
+	return n, err
+}
+
+// fields describing the body, left out when there is none
+func isFraming(key string) bool {
+	switch strings.ToLower(key) {
+	case "content-length", "transfer-encoding", "trailer":
+		return true
+	}
+	return false
+}
+
+// fields are checked before anything is written: a CR or LF in a value
+// would end the field line early and let the value add fields or a body
+// of its own (response splitting)
+func checkFields(hs ...*headers.Headers) error {
+	for _, h := range hs {
+		if h == nil {
+			continue
+		}
+		if err := h.Validate(); err != nil {
+			return fmt.Errorf("%w: %w", ERROR_INVALID_FIELD, err)
+		}
+	}
+	return nil
+}
+
+// State returns how far the response got
+func (w *Writer) State() WriterState {
+	return w.state
+}
+
+// Complete reports whether a full response was written. A body delimited
+// by closing the connection is complete once the handler stops writing,
+// a HEAD response as soon as its headers are out.
+func (w *Writer) Complete() bool {
+	if w.head && w.state > StateHeaders {
+		return true
+	}
+	return w.state == StateDone || w.state == StateBodyEOF
+}
+
+// picks the body mode the headers announce, as a client will read it:
+// no body for 1xx, 204 and 304, chunked when it is the last transfer coding,
+// Content-Length otherwise, and without either until the connection closes
+func (w *Writer) framing(h headers.Headers) (WriterState, int64, error) {
+	if !BodyAllowed(w.status) {
+		return StateDone, 0, nil
+	}
+
+	te := h.List("transfer-encoding")
+	hasCL := h.Has("content-length")
+	if len(te) > 0 && hasCL {
+		return 0, 0, fmt.Errorf("%w: both Transfer-Encoding and Content-Length", ERROR_BAD_FRAMING)
+	}
+
+	if len(te) > 0 {
+		if strings.EqualFold(te[len(te)-1], "chunked") {
+			return StateChunked, 0, nil
+		}
+		return StateBodyEOF, 0, nil
+	}
+
+	if hasCL {
+		// 1*DIGIT, repeats only with the same value: what the request
+		// parser accepts, and so what a client can be expected to
+		n, err := h.Int("content-length")
+		if err != nil {
+			return 0, 0, fmt.Errorf("%w: Content-Length %q: %w", ERROR_BAD_FRAMING, h.Get("content-length"), err)
+		}
+		if n == 0 {
+			return StateDone, 0, nil
+		}
+		return StateBody, n, nil
+	}
+
+	return StateBodyEOF, 0, nil
+}
@@ -0,0 +1,225 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kalim-Asim/http-server/internal/headers"
+)
+
+type StatusCode int
+
+// the IANA HTTP status code registry
+const (
+	StatusContinue           StatusCode = 100
+	StatusSwitchingProtocols StatusCode = 101
+	StatusProcessing         StatusCode = 102
+	StatusEarlyHints         StatusCode = 103
+
+	StatusOK                   StatusCode = 200
+	StatusCreated              StatusCode = 201
+	StatusAccepted             StatusCode = 202
+	StatusNonAuthoritativeInfo StatusCode = 203
+	StatusNoContent            StatusCode = 204
+	StatusResetContent         StatusCode = 205
+	StatusPartialContent       StatusCode = 206
+	StatusMultiStatus          StatusCode = 207
+	StatusAlreadyReported      StatusCode = 208
+	StatusIMUsed               StatusCode = 226
+
+	StatusMultipleChoices   StatusCode = 300
+	StatusMovedPermanently  StatusCode = 301
+	StatusFound             StatusCode = 302
+	StatusSeeOther          StatusCode = 303
+	StatusNotModified       StatusCode = 304
+	StatusUseProxy          StatusCode = 305
+	StatusTemporaryRedirect StatusCode = 307
+	StatusPermanentRedirect StatusCode = 308
+
+	StatusBadRequest                  StatusCode = 400
+	StatusUnauthorized                StatusCode = 401
+	StatusPaymentRequired             StatusCode = 402
+	StatusForbidden                   StatusCode = 403
+	StatusNotFound                    StatusCode = 404
+	StatusMethodNotAllowed            StatusCode = 405
+	StatusNotAcceptable               StatusCode = 406
+	StatusProxyAuthRequired           StatusCode = 407
+	StatusRequestTimeout              StatusCode = 408
+	StatusConflict                    StatusCode = 409
+	StatusGone                        StatusCode = 410
+	StatusLengthRequired              StatusCode = 411
+	StatusPreconditionFailed          StatusCode = 412
+	StatusContentTooLarge             StatusCode = 413
+	StatusURITooLong                  StatusCode = 414
+	StatusUnsupportedMediaType        StatusCode = 415
+	StatusRangeNotSatisfiable         StatusCode = 416
+	StatusExpectationFailed           StatusCode = 417
+	StatusMisdirectedRequest          StatusCode = 421
+	StatusUnprocessableContent        StatusCode = 422
+	StatusLocked                      StatusCode = 423
+	StatusFailedDependency            StatusCode = 424
+	StatusTooEarly                    StatusCode = 425
+	StatusUpgradeRequired             StatusCode = 426
+	StatusPreconditionRequired        StatusCode = 428
+	StatusTooManyRequests             StatusCode = 429
+	StatusRequestHeaderFieldsTooLarge StatusCode = 431
+	StatusUnavailableForLegalReasons  StatusCode = 451
+
+	StatusInternalServerError           StatusCode = 500
+	StatusNotImplemented                StatusCode = 501
+	StatusBadGateway                    StatusCode = 502
+	StatusServiceUnavailable            StatusCode = 503
+	StatusGatewayTimeout                StatusCode = 504
+	StatusHTTPVersionNotSupported       StatusCode = 505
+	StatusVariantAlsoNegotiates         StatusCode = 506
+	StatusInsufficientStorage           StatusCode = 507
+	StatusLoopDetected                  StatusCode = 508
+	StatusNotExtended                   StatusCode = 510
+	StatusNetworkAuthenticationRequired StatusCode = 511
+)
+
+var statusText = map[StatusCode]string{
+	StatusContinue:           "Continue",
+	StatusSwitchingProtocols: "Switching Protocols",
+	StatusProcessing:         "Processing",
+	StatusEarlyHints:         "Early Hints",
+
+	StatusOK:                   "OK",
+	StatusCreated:              "Created",
+	StatusAccepted:             "Accepted",
+	StatusNonAuthoritativeInfo: "Non-Authoritative Information",
+	StatusNoContent:            "No Content",
+	StatusResetContent:         "Reset Content",
+	StatusPartialContent:       "Partial Content",
+	StatusMultiStatus:          "Multi-Status",
+	StatusAlreadyReported:      "Already Reported",
+	StatusIMUsed:               "IM Used",
+
+	StatusMultipleChoices:   "Multiple Choices",
+	StatusMovedPermanently:  "Moved Permanently",
+	StatusFound:             "Found",
+	StatusSeeOther:          "See Other",
+	StatusNotModified:       "Not Modified",
+	StatusUseProxy:          "Use Proxy",
+	StatusTemporaryRedirect: "Temporary Redirect",
+	StatusPermanentRedirect: "Permanent Redirect",
+
+	StatusBadRequest:                  "Bad Request",
+	StatusUnauthorized:                "Unauthorized",
+	StatusPaymentRequired:             "Payment Required",
+	StatusForbidden:                   "Forbidden",
+	StatusNotFound:                    "Not Found",
+	StatusMethodNotAllowed:            "Method Not Allowed",
+	StatusNotAcceptable:               "Not Acceptable",
+	StatusProxyAuthRequired:           "Proxy Authentication Required",
+	StatusRequestTimeout:              "Request Timeout",
+	StatusConflict:                    "Conflict",
+	StatusGone:                        "Gone",
+	StatusLengthRequired:              "Length Required",
+	StatusPreconditionFailed:          "Precondition Failed",
+	StatusContentTooLarge:             "Content Too Large",
+	StatusURITooLong:                  "URI Too Long",
+	StatusUnsupportedMediaType:        "Unsupported Media Type",
+	StatusRangeNotSatisfiable:         "Range Not Satisfiable",
+	StatusExpectationFailed:           "Expectation Failed",
+	StatusMisdirectedRequest:          "Misdirected Request",
+	StatusUnprocessableContent:        "Unprocessable Content",
+	StatusLocked:                      "Locked",
+	StatusFailedDependency:            "Failed Dependency",
+	StatusTooEarly:                    "Too Early",
+	StatusUpgradeRequired:             "Upgrade Required",
+	StatusPreconditionRequired:        "Precondition Required",
+	StatusTooManyRequests:             "Too Many Requests",
+	StatusRequestHeaderFieldsTooLarge: "Request Header Fields Too Large",
+	StatusUnavailableForLegalReasons:  "Unavailable For Legal Reasons",
+
+	StatusInternalServerError:           "Internal Server Error",
+	StatusNotImplemented:                "Not Implemented",
+	StatusBadGateway:                    "Bad Gateway",
+	StatusServiceUnavailable:            "Service Unavailable",
+	StatusGatewayTimeout:                "Gateway Timeout",
+	StatusHTTPVersionNotSupported:       "HTTP Version Not Supported",
+	StatusVariantAlsoNegotiates:         "Variant Also Negotiates",
+	StatusInsufficientStorage:           "Insufficient Storage",
+	StatusLoopDetected:                  "Loop Detected",
+	StatusNotExtended:                   "Not Extended",
+	StatusNetworkAuthenticationRequired: "Network Authentication Required",
+}
+
+// StatusText returns the reason phrase for code,
+// "" for codes we do not know
+func StatusText(code StatusCode) string {
+	return statusText[code]
+}
+
+// BodyAllowed reports whether a response with code can carry a body,
+// 1xx, 204 and 304 responses never do
+func BodyAllowed(code StatusCode) bool {
+	return code >= 200 && code != 204 && code != 304
+}
+
+var ERROR_BAD_STATUS = errors.New("invalid status")
+
+// codes are three digits, the first one 1 to 5. The reason phrase may
+// hold any visible characters, spaces and tabs, but no line breaks
+func checkStatus(code StatusCode, reason string) error {
+	if code < 100 || code > 599 {
+		return fmt.Errorf("%w: code %d out of range 100-599", ERROR_BAD_STATUS, code)
+	}
+	for i := 0; i < len(reason); i++ {
+		c := reason[i]
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return fmt.Errorf("%w: reason phrase %q", ERROR_BAD_STATUS, reason)
+		}
+	}
+	return nil
+}
+
+// WriteStatusLineReason is WriteStatusLine with a reason phrase of our own.
+// Clients ignore it, it is just for humans reading the response.
+func (w *Writer) WriteStatusLineReason(statusCode StatusCode, reason string) error {
+	if w.state != StateStatus && w.state != StateHeaders {
+		return w.outOfOrder("WriteStatusLine")
+	}
+	if err := checkStatus(statusCode, reason); err != nil {
+		return err
+	}
+	if statusCode < 200 {
+		return fmt.Errorf("%w: %d is informational, see WriteInformational", ERROR_BAD_STATUS, statusCode)
+	}
+	w.state = StateHeaders
+	w.status = statusCode
+	w.statusLine = fmt.Appendf(w.statusLine[:0], "HTTP/1.1 %d %s\r\n", statusCode, reason)
+	return nil
+}
+
+// WriteInformational sends an interim 1xx response ahead of the final one,
+// e.g. 100 Continue before reading a large body or 103 Early Hints with
+// Link fields. It can be called any number of times before WriteStatusLine.
+// 101 Switching Protocols ends HTTP on the connection and is not supported.
+// Content-Length, Transfer-Encoding and Trailer are left out, a 1xx
+// response has no body to frame.
+func (w *Writer) WriteInformational(statusCode StatusCode, h *headers.Headers) error {
+	if w.state != StateStatus {
+		return w.outOfOrder("WriteInformational")
+	}
+	if statusCode < 100 || statusCode > 199 || statusCode == StatusSwitchingProtocols {
+		return fmt.Errorf("%w: %d is not an informational status", ERROR_BAD_STATUS, statusCode)
+	}
+	if err := checkFields(h); err != nil {
+		return err
+	}
+
+	b := fmt.Appendf(nil, "HTTP/1.1 %d %s\r\n", statusCode, StatusText(statusCode))
+	if h != nil {
+		h.ForEach(func(key, val string) {
+			if !isFraming(key) {
+				b = w.appendField(b, key, val)
+			}
+		})
+	}
+	b = fmt.Appendf(b, "\r\n")
+
+	_, err := w.writer.Write(b)
+	return err
+}
@@ -0,0 +1,262 @@
+package router
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/kalim-Asim/http-server/internal/request"
+	"github.com/kalim-Asim/http-server/internal/response"
+	"github.com/kalim-Asim/http-server/internal/server"
+)
+
+/* -------------  PATTERN FORMAT  ----------------
+
+pattern   = "/" *( segment "/" ) [ segment | wildcard ]
+segment   = literal | "{" name "}"
+wildcard  = "{" name "...}"      ; matches the rest of the path
+
+	/users/{id}          -> /users/42           id=42
+	/static/{path...}    -> /static/css/a.css   path=css/a.css
+*/
+
+type segmentKind int
+
+const (
+	kindLiteral segmentKind = iota
+	kindParam
+	kindWildcard
+)
+
+type segment struct {
+	kind  segmentKind
+	value string // literal text or parameter name
+}
+
+type route struct {
+	method   string
+	pattern  string
+	segments []segment
+	handler  server.Handler
+}
+
+// table is shared between a router and all of its groups
+type table struct {
+	routes []*route
+}
+
+// Router dispatches requests by method and path pattern.
+// Its Serve method is a server.Handler.
+type Router struct {
+	table  *table
+	prefix string
+
+	// called when no pattern matches the path, defaults to a plain 404
+	NotFound server.Handler
+}
+
+func New() *Router {
+	return &Router{
+		table: &table{},
+	}
+}
+
+// Group returns a router whose patterns are all prefixed with prefix.
+// Routes registered on it are served by the parent.
+func (r *Router) Group(prefix string) *Router {
+	return &Router{
+		table:  r.table,
+		prefix: r.prefix + strings.TrimSuffix(prefix, "/"),
+	}
+}
+
+// Handle registers handler for method and pattern.
+// It panics on a malformed pattern or a duplicate registration,
+// both are programming errors.
+func (r *Router) Handle(method, pattern string, handler server.Handler) {
+	pattern = r.prefix + pattern
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("router: pattern %q: %v", pattern, err))
+	}
+
+	for _, rt := range r.table.routes {
+		if rt.method == method && rt.pattern == pattern {
+			panic(fmt.Sprintf("router: %s %s registered twice", method, pattern))
+		}
+	}
+
+	r.table.routes = append(r.table.routes, &route{
+		method:   method,
+		pattern:  pattern,
+		segments: segments,
+		handler:  handler,
+	})
+}
+
+func (r *Router) Get(pattern string, handler server.Handler) {
+	r.Handle("GET", pattern, handler)
+}
+
+func (r *Router) Post(pattern string, handler server.Handler) {
+	r.Handle("POST", pattern, handler)
+}
+
+func (r *Router) Put(pattern string, handler server.Handler) {
+	r.Handle("PUT", pattern, handler)
+}
+
+func (r *Router) Delete(pattern string, handler server.Handler) {
+	r.Handle("DELETE", pattern, handler)
+}
+
+// Serve picks the most specific route for the request path. HEAD requests
+// fall back to the GET route. A path that matches only routes of other
+// methods gets 405 with an Allow header.
+func (r *Router) Serve(w *response.Writer, req *request.Request) {
+	parts := splitPath(req.Path())
+
+	method := req.RequestLine.Method
+	var best *route
+	var bestParams map[string]string
+	allowed := []string{}
+
+	for _, rt := range r.table.routes {
+		params, ok := rt.match(parts)
+		if !ok {
+			continue
+		}
+		// HEAD is served by the GET handler, the writer drops the body
+		if rt.method != method && !(method == "HEAD" && rt.method == "GET") {
+			if !slices.Contains(allowed, rt.method) {
+				allowed = append(allowed, rt.method)
+			}
+			continue
+		}
+		// an explicit HEAD route beats the GET fallback for the same pattern
+		if best == nil || rt.moreSpecific(best) ||
+			!best.moreSpecific(rt) && rt.method == method {
+			best, bestParams = rt, params
+		}
+	}
+
+	if best != nil {
+		req.PathParams = bestParams
+		best.handler(w, req)
+		return
+	}
+
+	if len(allowed) > 0 {
+		if slices.Contains(allowed, "GET") && !slices.Contains(allowed, "HEAD") {
+			allowed = append(allowed, "HEAD")
+		}
+		slices.Sort(allowed)
+		w.AddHeader("Allow", strings.Join(allowed, ", "))
+		server.WriteError(w, req, server.Errorf(response.StatusMethodNotAllowed, "Method Not Allowed"))
+		return
+	}
+
+	if r.NotFound != nil {
+		r.NotFound(w, req)
+		return
+	}
+	server.WriteError(w, req, server.Errorf(response.StatusNotFound, "Not Found"))
+}
+
+// returns the path parameters when parts fit the route
+func (rt *route) match(parts []string) (map[string]string, bool) {
+	params := map[string]string{}
+
+	for i, seg := range rt.segments {
+		if seg.kind == kindWildcard {
+			params[seg.value] = strings.Join(parts[i:], "/")
+			return params, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+
+		switch seg.kind {
+		case kindLiteral:
+			if parts[i] != seg.value {
+				return nil, false
+			}
+		case kindParam:
+			if parts[i] == "" {
+				return nil, false
+			}
+			params[seg.value] = parts[i]
+		}
+	}
+
+	if len(parts) != len(rt.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+// literal beats parameter beats wildcard, compared segment by segment
+func (rt *route) moreSpecific(other *route) bool {
+	for i := 0; i < len(rt.segments) && i < len(other.segments); i++ {
+		if rt.segments[i].kind != other.segments[i].kind {
+			return rt.segments[i].kind < other.segments[i].kind
+		}
+	}
+	return len(rt.segments) > len(other.segments)
+}
+
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("must start with /")
+	}
+
+	parts := strings.Split(pattern[1:], "/")
+	segments := make([]segment, 0, len(parts))
+	names := map[string]bool{}
+
+	for i, part := range parts {
+		if !strings.HasPrefix(part, "{") {
+			if strings.ContainsAny(part, "{}") {
+				return nil, fmt.Errorf("braces must wrap a whole segment")
+			}
+			segments = append(segments, segment{kind: kindLiteral, value: part})
+			continue
+		}
+
+		if !strings.HasSuffix(part, "}") {
+			return nil, fmt.Errorf("unclosed brace in %q", part)
+		}
+		name := part[1 : len(part)-1]
+		kind := kindParam
+		if strings.HasSuffix(name, "...") {
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("wildcard %q must be the last segment", part)
+			}
+			name = strings.TrimSuffix(name, "...")
+			kind = kindWildcard
+		}
+		if name == "" || strings.ContainsAny(name, "{}") {
+			return nil, fmt.Errorf("bad parameter name %q", part)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicate parameter %q", name)
+		}
+		names[name] = true
+
+		segments = append(segments, segment{kind: kind, value: name})
+	}
+
+	return segments, nil
+}
+
+// splits an escaped path into decoded segments, "/" -> [""]
+func splitPath(path string) []string {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, part := range parts {
+		if unescaped, err := url.PathUnescape(part); err == nil {
+			parts[i] = unescaped
+		}
+	}
+	return parts
+}
@@ -0,0 +1,125 @@
+package router
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/kalim-Asim/http-server/internal/request"
+	"github.com/kalim-Asim/http-server/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runs a request line through r and returns the raw response
+func serve(t *testing.T, r *Router, method, target string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	r.Serve(response.NewWriter(&buf), req)
+	return buf.String()
+}
+
+// answers with the route name and its path parameters
+func named(name string, params ...string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		body := name
+		for _, p := range params {
+			body += " " + p + "=" + req.PathParam(p)
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}
+}
+
+func TestRouter(t *testing.T) {
+	r := New()
+	r.Get("/", named("root"))
+	r.Get("/users/{id}", named("user", "id"))
+	r.Get("/users/me", named("me"))
+	r.Put("/users/{id}", named("put-user", "id"))
+	r.Get("/static/{path...}", named("static", "path"))
+	r.Get("/static/index.html", named("index"))
+
+	api := r.Group("/api")
+	api.Post("/items", named("create-item"))
+	v1 := api.Group("/v1/")
+	v1.Delete("/items/{id}", named("delete-item", "id"))
+
+	t.Run("Root", func(t *testing.T) {
+		assert.True(t, strings.HasSuffix(serve(t, r, "GET", "/"), "\r\n\r\nroot"))
+	})
+
+	t.Run("Path parameter", func(t *testing.T) {
+		assert.True(t, strings.HasSuffix(serve(t, r, "GET", "/users/42"), "user id=42"))
+		assert.True(t, strings.HasSuffix(serve(t, r, "PUT", "/users/42"), "put-user id=42"))
+	})
+
+	t.Run("Percent-encoded parameter", func(t *testing.T) {
+		assert.True(t, strings.HasSuffix(serve(t, r, "GET", "/users/a%20b"), "user id=a b"))
+	})
+
+	t.Run("Query string is ignored", func(t *testing.T) {
+		assert.True(t, strings.HasSuffix(serve(t, r, "GET", "/users/42?verbose=1"), "user id=42"))
+	})
+
+	t.Run("Literal beats parameter", func(t *testing.T) {
+		assert.True(t, strings.HasSuffix(serve(t, r, "GET", "/users/me"), "me"))
+	})
+
+	t.Run("Wildcard", func(t *testing.T) {
+		assert.True(t, strings.HasSuffix(serve(t, r, "GET", "/static/css/site.css"), "static path=css/site.css"))
+		assert.True(t, strings.HasSuffix(serve(t, r, "GET", "/static/index.html"), "index"))
+	})
+
+	t.Run("Groups", func(t *testing.T) {
+		assert.True(t, strings.HasSuffix(serve(t, r, "POST", "/api/items"), "create-item"))
+		assert.True(t, strings.HasSuffix(serve(t, r, "DELETE", "/api/v1/items/7"), "delete-item id=7"))
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		res := serve(t, r, "GET", "/nope")
+		assert.True(t, strings.HasPrefix(res, "HTTP/1.1 404 Not Found\r\n"))
+
+		res = serve(t, r, "GET", "/users/42/extra")
+		assert.True(t, strings.HasPrefix(res, "HTTP/1.1 404 Not Found\r\n"))
+	})
+
+	t.Run("Method not allowed", func(t *testing.T) {
+		res := serve(t, r, "POST", "/users/42")
+		assert.True(t, strings.HasPrefix(res, "HTTP/1.1 405 Method Not Allowed\r\n"))
+		assert.Contains(t, res, "Allow: GET, HEAD, PUT\r\n")
+	})
+
+	t.Run("HEAD falls back to GET", func(t *testing.T) {
+		r := New()
+		r.Get("/users/{id}", named("get-user", "id"))
+		r.Get("/files", named("get-files"))
+		r.Handle("HEAD", "/files", named("head-files"))
+
+		assert.True(t, strings.HasSuffix(serve(t, r, "HEAD", "/users/42"), "get-user id=42"))
+		assert.True(t, strings.HasSuffix(serve(t, r, "HEAD", "/files"), "head-files"))
+	})
+
+	t.Run("Custom not found", func(t *testing.T) {
+		r := New()
+		r.NotFound = named("custom")
+		assert.True(t, strings.HasSuffix(serve(t, r, "GET", "/x"), "custom"))
+	})
+}
+
+func TestBadPatterns(t *testing.T) {
+	r := New()
+	assert.Panics(t, func() { r.Get("users", named("x")) })
+	assert.Panics(t, func() { r.Get("/users/{id", named("x")) })
+	assert.Panics(t, func() { r.Get("/users/x{id}", named("x")) })
+	assert.Panics(t, func() { r.Get("/{rest...}/x", named("x")) })
+	assert.Panics(t, func() { r.Get("/{id}/{id}", named("x")) })
+	assert.Panics(t, func() { r.Get("/{}", named("x")) })
+
+	r.Get("/twice", named("x"))
+	assert.Panics(t, func() { r.Get("/twice", named("x")) })
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"strings"
+
+	"github.com/kalim-Asim/http-server/internal/headers"
+	"github.com/kalim-Asim/http-server/internal/request"
+	"github.com/kalim-Asim/http-server/internal/response"
+)
+
+// HandleErrors adapts an ErrorHandler to a Handler,
+// rendering whatever error it returns with WriteError
+func HandleErrors(h ErrorHandler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		if err := h(w, req); err != nil {
+			WriteError(w, req, err)
+		}
+	}
+}
+
+// WriteError sends err as a complete response. A *HandlerError keeps its
+// status and message, any other error becomes a 500 without details.
+// The body is plain text, HTML or JSON depending on the Accept header.
+// If the handler already sent its headers a second response would corrupt
+// the stream, so the connection is closed instead.
+func WriteError(w *response.Writer, req *request.Request, err error) {
+	var he *HandlerError
+	if !errors.As(err, &he) {
+		slog.Error("handler failed",
+			"method", req.RequestLine.Method,
+			"target", req.RequestLine.RequestTarget,
+			"error", err,
+		)
+		he = &HandlerError{
+			StatusCode: response.StatusInternalServerError,
+			Message:    []byte(response.StatusText(response.StatusInternalServerError)),
+		}
+	}
+
+	if w.State() > response.StateHeaders {
+		w.SetKeepAlive(false)
+		return
+	}
+
+	contentType := negotiate(req.Headers.Get("accept"), "text/plain", "text/html", "application/json")
+	body := renderError(he, contentType)
+
+	h := response.GetDefaultHeaders(len(body))
+	h.Set("Content-Type", contentType)
+	w.WriteStatusLine(he.StatusCode)
+	w.WriteHeaders(*h)
+	w.WriteBody(body)
+}
+
+func renderError(he *HandlerError, contentType string) []byte {
+	text := response.StatusText(he.StatusCode)
+
+	switch contentType {
+	case "text/html":
+		return fmt.Appendf(nil, `<html>
+  <head>
+    <title>%d %s</title>
+  </head>
+  <body>
+    <h1>%s</h1>
+    <p>%s</p>
+  </body>
+</html>
+`, he.StatusCode, text, text, html.EscapeString(string(he.Message)))
+
+	case "application/json":
+		b, _ := json.Marshal(struct {
+			Status int    `json:"status"`
+			Error  string `json:"error"`
+		}{int(he.StatusCode), string(he.Message)})
+		return append(b, '\n')
+
+	default:
+		return fmt.Appendf(nil, "%s\n", he.Message)
+	}
+}
+
+// negotiate picks the offer the Accept header ranks highest. Each offer
+// gets the q-value of the most specific media range matching it, ties go
+// to the earlier offer. Without a usable Accept the first offer wins.
+func negotiate(accept string, offers ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	best, bestQ := offers[0], 0.0
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+		for _, r := range headers.ParseList(accept) {
+			e := headers.ParseElement(r)
+			s := matchMediaRange(strings.ToLower(e.Value), offer)
+			if s > specificity {
+				q, specificity = e.Q(), s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// how specifically mediaRange matches offer:
+// -1 no match, 0 */*, 1 type/*, 2 exact
+func matchMediaRange(mediaRange, offer string) int {
+	switch {
+	case mediaRange == offer:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") &&
+		strings.HasPrefix(offer, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	}
+	return -1
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kalim-Asim/http-server/internal/request"
+	"github.com/kalim-Asim/http-server/internal/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleErrors(t *testing.T) {
+	notFound := HandleErrors(func(w *response.Writer, req *request.Request) error {
+		return Errorf(response.StatusNotFound, "no <such> thing")
+	})
+
+	t.Run("Plain text by default", func(t *testing.T) {
+		var buf bytes.Buffer
+		notFound(response.NewWriter(&buf), newRequest(t, "GET / HTTP/1.1\r\n\r\n"))
+
+		res := buf.String()
+		assert.True(t, strings.HasPrefix(res, "HTTP/1.1 404 Not Found\r\n"))
+		assert.Contains(t, res, "Content-Type: text/plain\r\n")
+		assert.True(t, strings.HasSuffix(res, "\r\n\r\nno <such> thing\n"))
+	})
+
+	t.Run("HTML for browsers", func(t *testing.T) {
+		var buf bytes.Buffer
+		notFound(response.NewWriter(&buf), newRequest(t, "GET / HTTP/1.1\r\n"+
+			"Accept: text/html,application/xhtml+xml,*/*;q=0.8\r\n\r\n"))
+
+		res := buf.String()
+		assert.Contains(t, res, "Content-Type: text/html\r\n")
+		assert.Contains(t, res, "<title>404 Not Found</title>")
+		assert.Contains(t, res, "<p>no &lt;such&gt; thing</p>")
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var buf bytes.Buffer
+		notFound(response.NewWriter(&buf), newRequest(t, "GET / HTTP/1.1\r\nAccept: application/json\r\n\r\n"))
+
+		res := buf.String()
+		assert.Contains(t, res, "Content-Type: application/json\r\n")
+		assert.True(t, strings.HasSuffix(res, "\r\n\r\n{\"status\":404,\"error\":\"no \\u003csuch\\u003e thing\"}\n"))
+	})
+
+	t.Run("Plain errors become a bare 500", func(t *testing.T) {
+		h := HandleErrors(func(w *response.Writer, req *request.Request) error {
+			return fmt.Errorf("open /secret/path: permission denied")
+		})
+
+		var buf bytes.Buffer
+		h(response.NewWriter(&buf), newRequest(t, "GET / HTTP/1.1\r\n\r\n"))
+
+		res := buf.String()
+		assert.True(t, strings.HasPrefix(res, "HTTP/1.1 500 Internal Server Error\r\n"))
+		assert.NotContains(t, res, "secret")
+	})
+
+	t.Run("Headers already sent closes the connection", func(t *testing.T) {
+		h := HandleErrors(func(w *response.Writer, req *request.Request) error {
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*response.GetDefaultHeaders(10))
+			return Errorf(response.StatusInternalServerError, "too late")
+		})
+
+		var buf bytes.Buffer
+		w := response.NewWriter(&buf)
+		h(w, newRequest(t, "GET / HTTP/1.1\r\n\r\n"))
+
+		assert.Equal(t, 1, strings.Count(buf.String(), "HTTP/1.1"))
+		assert.NotContains(t, buf.String(), "too late")
+		assert.False(t, w.KeepAlive())
+	})
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{"text/plain", "text/html", "application/json"}
+
+	assert.Equal(t, "text/plain", negotiate("", offers...))
+	assert.Equal(t, "text/plain", negotiate("*/*", offers...))
+	assert.Equal(t, "text/html", negotiate("text/html", offers...))
+	assert.Equal(t, "application/json", negotiate("application/*", offers...))
+	assert.Equal(t, "application/json", negotiate("text/*;q=0.5, application/json", offers...))
+	assert.Equal(t, "text/html", negotiate("text/*, text/plain;q=0.1", offers...))
+	assert.Equal(t, "text/plain", negotiate("image/png", offers...))
+	assert.Equal(t, "text/html", negotiate("TEXT/HTML;Q=0.9, */*;q=0.1", offers...))
+}
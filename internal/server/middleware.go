@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/kalim-Asim/http-server/internal/request"
+	"github.com/kalim-Asim/http-server/internal/response"
+)
+
+// Middleware wraps a Handler. Code before the call to next runs before
+// the response is written, code after it can inspect what was written
+// through w.Status and w.BytesWritten.
+type Middleware func(next Handler) Handler
+
+// Chain wraps h with mws, the first middleware being the outermost:
+// Chain(h, a, b) runs a, then b, then h
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// Recover turns a panicking handler into a 500. If the handler already
+// started its response the connection is closed instead, a second
+// status line would corrupt the stream.
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+
+				slog.Error("handler panic",
+					"method", req.RequestLine.Method,
+					"target", req.RequestLine.RequestTarget,
+					"panic", v,
+					"stack", string(debug.Stack()),
+				)
+
+				w.SetKeepAlive(false)
+				if w.State() > response.StateHeaders {
+					return
+				}
+				body := []byte("Internal Server Error\n")
+				w.WriteStatusLine(response.StatusInternalServerError)
+				w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+				w.WriteBody(body)
+			}()
+
+			next(w, req)
+		}
+	}
+}
+
+const RequestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the id RequestID attached to ctx, "" if none
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestID makes sure every request carries an X-Request-Id, keeping a
+// sane one sent by the client (or a proxy in front of us) and generating
+// one otherwise. The id is echoed in the response and attached to the
+// request context, see RequestIDFromContext.
+func RequestID() Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			id := req.Headers.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+				req.Headers.Set(RequestIDHeader, id)
+			}
+			w.AddHeader(RequestIDHeader, id)
+
+			next(w, req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id)))
+		}
+	}
+}
+
+// 16 random bytes, hex encoded
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ids from outside end up in logs and response headers,
+// only short visible ascii is accepted
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Timing logs every request once its response is written,
+// with status, body size and how long the handler took.
+// canceled tells whether the client went away (or the deadline hit)
+// before the handler was done
+func Timing() Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+
+			next(w, req)
+
+			slog.Info("request",
+				"method", req.RequestLine.Method,
+				"target", req.RequestLine.RequestTarget,
+				"status", int(w.Status()),
+				"bytes", w.BytesWritten(),
+				"duration", time.Since(start),
+				"request_id", RequestIDFromContext(req.Context()),
+				"canceled", req.Context().Err() != nil,
+			)
+		}
+	}
+}
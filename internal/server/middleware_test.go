@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/kalim-Asim/http-server/internal/request"
+	"github.com/kalim-Asim/http-server/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(t *testing.T, raw string) *request.Request {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return req
+}
+
+func writeOK(w *response.Writer, req *request.Request) {
+	body := []byte("ok")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func TestChain(t *testing.T) {
+	order := []string{}
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(w *response.Writer, req *request.Request) {
+				order = append(order, name+" before")
+				next(w, req)
+				order = append(order, name+" after")
+			}
+		}
+	}
+
+	h := Chain(func(w *response.Writer, req *request.Request) {
+		order = append(order, "handler")
+		writeOK(w, req)
+	}, trace("a"), trace("b"))
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	h(w, newRequest(t, "GET / HTTP/1.1\r\n\r\n"))
+
+	assert.Equal(t, []string{"a before", "b before", "handler", "b after", "a after"}, order)
+	assert.Equal(t, response.StatusOK, w.Status())
+	assert.Equal(t, int64(2), w.BytesWritten())
+}
+
+func TestRecover(t *testing.T) {
+	t.Run("Panic before writing", func(t *testing.T) {
+		h := Chain(func(w *response.Writer, req *request.Request) {
+			panic("boom")
+		}, Recover())
+
+		var buf bytes.Buffer
+		w := response.NewWriter(&buf)
+		h(w, newRequest(t, "GET / HTTP/1.1\r\n\r\n"))
+
+		assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 500 Internal Server Error\r\n"))
+		assert.False(t, w.KeepAlive())
+	})
+
+	t.Run("Panic after the status line", func(t *testing.T) {
+		h := Chain(func(w *response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusOK)
+			panic("boom")
+		}, Recover())
+
+		var buf bytes.Buffer
+		w := response.NewWriter(&buf)
+		h(w, newRequest(t, "GET / HTTP/1.1\r\n\r\n"))
+
+		// held, not sent: the 500 replaces it
+		assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 500 Internal Server Error\r\n"))
+		assert.False(t, w.KeepAlive())
+	})
+
+	t.Run("Panic after the headers", func(t *testing.T) {
+		h := Chain(func(w *response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*response.GetDefaultHeaders(2))
+			panic("boom")
+		}, Recover())
+
+		var buf bytes.Buffer
+		w := response.NewWriter(&buf)
+		h(w, newRequest(t, "GET / HTTP/1.1\r\n\r\n"))
+
+		assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Type: text/plain\r\n\r\n", buf.String())
+		assert.False(t, w.KeepAlive())
+	})
+}
+
+func TestRequestID(t *testing.T) {
+	t.Run("Generated", func(t *testing.T) {
+		var seen string
+		h := Chain(func(w *response.Writer, req *request.Request) {
+			seen = req.Headers.Get(RequestIDHeader)
+			writeOK(w, req)
+		}, RequestID())
+
+		var buf bytes.Buffer
+		h(response.NewWriter(&buf), newRequest(t, "GET / HTTP/1.1\r\n\r\n"))
+
+		assert.Len(t, seen, 32)
+		assert.Contains(t, buf.String(), "X-Request-Id: "+seen+"\r\n")
+	})
+
+	t.Run("Kept from the client", func(t *testing.T) {
+		h := Chain(writeOK, RequestID())
+
+		var buf bytes.Buffer
+		h(response.NewWriter(&buf), newRequest(t, "GET / HTTP/1.1\r\nX-Request-Id: abc-123\r\n\r\n"))
+
+		assert.Contains(t, buf.String(), "X-Request-Id: abc-123\r\n")
+	})
+
+	t.Run("Handler header wins", func(t *testing.T) {
+		h := Chain(func(w *response.Writer, req *request.Request) {
+			h := response.GetDefaultHeaders(0)
+			h.Set(RequestIDHeader, "mine")
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*h)
+		}, RequestID())
+
+		var buf bytes.Buffer
+		h(response.NewWriter(&buf), newRequest(t, "GET / HTTP/1.1\r\n\r\n"))
+
+		assert.Equal(t, 1, strings.Count(strings.ToLower(buf.String()), "x-request-id"))
+		assert.Contains(t, buf.String(), ": mine\r\n")
+	})
+}
@@ -0,0 +1,353 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/kalim-Asim/http-server/internal/headers"
+	"github.com/kalim-Asim/http-server/internal/request"
+	"github.com/kalim-Asim/http-server/internal/response"
+)
+
+// adapters between our Handler and net/http, so existing net/http code
+// can run on this server (and the other way round) while migrating
+
+// FromHTTP runs a net/http handler on this server. The http.ResponseWriter
+// it gets implements http.Flusher and supports trailers, either declared
+// in the "Trailer" header or set with the http.TrailerPrefix.
+func FromHTTP(h http.Handler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		hreq, err := toHTTPRequest(req)
+		if err != nil {
+			WriteError(w, req, Errorf(response.StatusBadRequest, "%v", err))
+			return
+		}
+
+		rw := &httpResponseWriter{
+			w:      w,
+			header: http.Header{},
+		}
+		h.ServeHTTP(rw, hreq)
+		rw.finish()
+	}
+}
+
+// ToHTTP exposes a Handler as a net/http handler. The raw response it
+// writes is parsed back with http.ReadResponse, so framing, chunked
+// encoding and trailers go through net/http's own checks.
+func ToHTTP(h Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		req := fromHTTPRequest(r)
+
+		pr, pw := io.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			w := response.NewWriter(pw)
+			w.SetMethod(r.Method)
+			h(w, req)
+			pw.Close()
+		}()
+		defer func() {
+			// unblocks a handler still writing what nobody reads
+			pr.Close()
+			<-done
+		}()
+
+		res, err := http.ReadResponse(bufio.NewReader(pr), r)
+		if err != nil {
+			http.Error(rw, "malformed response from handler", http.StatusInternalServerError)
+			return
+		}
+		defer res.Body.Close()
+
+		for key, values := range res.Header {
+			if isHopByHop(key) {
+				continue
+			}
+			rw.Header()[key] = values
+		}
+		for key := range res.Trailer {
+			rw.Header().Add("Trailer", key)
+		}
+		rw.WriteHeader(res.StatusCode)
+
+		// pass streamed bodies on as they come
+		flusher, _ := rw.(http.Flusher)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := res.Body.Read(buf)
+			if n > 0 {
+				if _, err := rw.Write(buf[:n]); err != nil {
+					return
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+
+		// only known once the body is read to the end
+		for key, values := range res.Trailer {
+			rw.Header()[key] = values
+		}
+	})
+}
+
+// fields that describe our connection, net/http sets its own
+func isHopByHop(key string) bool {
+	switch http.CanonicalHeaderKey(key) {
+	case "Connection", "Keep-Alive", "Transfer-Encoding", "Trailer", "Upgrade", "Te":
+		return true
+	}
+	return false
+}
+
+func toHTTPRequest(req *request.Request) (*http.Request, error) {
+	target := req.RequestLine.RequestTarget
+	u, err := url.ParseRequestURI(target)
+	if err != nil {
+		return nil, fmt.Errorf("bad request target %q", target)
+	}
+
+	header := http.Header{}
+	req.Headers.ForEach(func(key, val string) {
+		header.Add(key, val)
+	})
+
+	hreq := (&http.Request{
+		Method:        req.RequestLine.Method,
+		URL:           u,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		ContentLength: req.ContentLength,
+		Host:          header.Get("Host"),
+		RequestURI:    target,
+	}).WithContext(req.Context())
+	header.Del("Host")
+
+	if req.ContentLength == -1 {
+		hreq.TransferEncoding = []string{"chunked"}
+	}
+	if req.Body == request.NoBody {
+		hreq.Body = http.NoBody
+	} else {
+		hreq.Body = &trailerBody{
+			ReadCloser: req.Body,
+			onEOF: func() {
+				hreq.Trailer = http.Header{}
+				req.Trailers.ForEach(func(key, val string) {
+					hreq.Trailer.Add(key, val)
+				})
+			},
+		}
+	}
+
+	return hreq, nil
+}
+
+func fromHTTPRequest(r *http.Request) *request.Request {
+	req := request.NewRequest().WithContext(r.Context())
+	req.State = request.StateDone
+	req.RequestLine = request.RequestLine{
+		Method:        r.Method,
+		RequestTarget: r.URL.RequestURI(),
+		HttpVersion:   "1.1",
+	}
+
+	addFields(&req.Headers, r.Header)
+	if r.Host != "" {
+		req.Headers.Set("Host", r.Host)
+	}
+
+	req.ContentLength = r.ContentLength
+	if r.Body != nil && r.Body != http.NoBody {
+		req.Body = &trailerBody{
+			ReadCloser: r.Body,
+			onEOF: func() {
+				addFields(req.Trailers, r.Trailer)
+			},
+		}
+	}
+
+	return req
+}
+
+// adds every value of an http.Header, in a fixed order
+func addFields(h *headers.Headers, header http.Header) {
+	for _, key := range slices.Sorted(maps.Keys(header)) {
+		for _, value := range header[key] {
+			h.Add(key, value)
+		}
+	}
+}
+
+// copies the trailers over once the body has been read to the end
+type trailerBody struct {
+	io.ReadCloser
+	onEOF func()
+}
+
+func (b *trailerBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF && b.onEOF != nil {
+		b.onEOF()
+		b.onEOF = nil
+	}
+	return n, err
+}
+
+// http.ResponseWriter on top of response.Writer. The status line and
+// headers go out with the first Write or Flush; without a Content-Length
+// by then the body is sent through a response.ChunkedWriter.
+type httpResponseWriter struct {
+	w      *response.Writer
+	header http.Header
+
+	status      int
+	wroteHeader bool // handler called WriteHeader (or Write)
+	sentHeader  bool // status line and headers are on the wire
+	chunked     *response.ChunkedWriter
+}
+
+func (rw *httpResponseWriter) Header() http.Header {
+	return rw.header
+}
+
+func (rw *httpResponseWriter) WriteHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+	if code >= 100 && code < 200 {
+		// sent right away, like net/http does
+		rw.w.WriteInformational(response.StatusCode(code), rw.fields(false))
+		return
+	}
+	rw.wroteHeader = true
+	rw.status = code
+}
+
+func (rw *httpResponseWriter) Write(p []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if !rw.sentHeader {
+		if rw.header.Get("Content-Type") == "" {
+			rw.header.Set("Content-Type", http.DetectContentType(p))
+		}
+		if err := rw.sendHeader(); err != nil {
+			return 0, err
+		}
+	}
+
+	if rw.chunked != nil {
+		return rw.chunked.Write(p)
+	}
+	return rw.w.WriteBody(p)
+}
+
+// Flush implements http.Flusher, sending the headers
+// and any buffered chunk
+func (rw *httpResponseWriter) Flush() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if !rw.sentHeader {
+		rw.sendHeader()
+	}
+	if rw.chunked != nil {
+		rw.chunked.Flush()
+	}
+}
+
+func (rw *httpResponseWriter) sendHeader() error {
+	rw.sentHeader = true
+
+	h := rw.fields(true)
+	if !h.Has("Content-Length") && response.BodyAllowed(response.StatusCode(rw.status)) {
+		rw.chunked = response.NewChunkedWriter(rw.w)
+		h.Set("Transfer-Encoding", "chunked")
+	}
+
+	if err := rw.w.WriteStatusLine(response.StatusCode(rw.status)); err != nil {
+		return err
+	}
+	return rw.w.WriteHeaders(*h)
+}
+
+// the header map as our fields, leaving out trailers set with
+// http.TrailerPrefix. Framing fields only belong to the final response.
+func (rw *httpResponseWriter) fields(final bool) *headers.Headers {
+	h := headers.NewHeaders()
+	for _, key := range slices.Sorted(maps.Keys(rw.header)) {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			continue
+		}
+		if !final && (key == "Content-Length" || key == "Transfer-Encoding" || key == "Trailer") {
+			continue
+		}
+		for _, value := range rw.header[key] {
+			h.Add(key, value)
+		}
+	}
+	return h
+}
+
+// completes the response once the handler returned
+func (rw *httpResponseWriter) finish() error {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if !rw.sentHeader {
+		// nothing written, the response is known to be empty
+		if rw.header.Get("Content-Length") == "" && response.BodyAllowed(response.StatusCode(rw.status)) {
+			rw.header.Set("Content-Length", "0")
+		}
+		if err := rw.sendHeader(); err != nil {
+			return err
+		}
+	}
+	if rw.chunked == nil {
+		return nil
+	}
+
+	rw.setTrailers(rw.chunked.Trailer())
+	return rw.chunked.Close()
+}
+
+// declared trailer names take their value from the header map, like
+// net/http does. http.TrailerPrefix keys need no declaration, their
+// names are declared on the chunked writer before it sends them;
+// fields not allowed in trailers are dropped.
+func (rw *httpResponseWriter) setTrailers(t *headers.Headers) {
+	for _, list := range rw.header.Values("Trailer") {
+		for _, key := range strings.Split(list, ",") {
+			key = http.CanonicalHeaderKey(strings.TrimSpace(key))
+			t.Delete(key)
+			for _, value := range rw.header.Values(key) {
+				t.Add(key, value)
+			}
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(rw.header)) {
+		name, ok := strings.CutPrefix(key, http.TrailerPrefix)
+		if ok && name != "" && response.TrailerAllowed(name) {
+			rw.chunked.DeclareTrailer(name)
+			t.Delete(name)
+			for _, value := range rw.header[key] {
+				t.Add(name, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/kalim-Asim/http-server/internal/headers"
+	"github.com/kalim-Asim/http-server/internal/request"
+	"github.com/kalim-Asim/http-server/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromHTTP(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /hello/{name}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Query", r.URL.Query().Get("q"))
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2")
+		w.Header().Set("Content-Length", "11")
+		fmt.Fprintf(w, "hello %s", r.PathValue("name"))
+	})
+	mux.HandleFunc("POST /echo", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+		w.Write([]byte(r.Trailer.Get("X-Sum")))
+	})
+	mux.HandleFunc("GET /stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Count, X-Late")
+		for i := range 3 {
+			fmt.Fprintf(w, "%d;", i)
+			w.(http.Flusher).Flush()
+		}
+		w.Header().Set("X-Count", "3")
+		w.Header().Set(http.TrailerPrefix+"X-Late", "yes")
+		// never announced, sent all the same
+		w.Header().Set(http.TrailerPrefix+"X-Undeclared", "no")
+		// would break the framing, dropped
+		w.Header().Set(http.TrailerPrefix+"Content-Length", "1")
+	})
+	mux.HandleFunc("GET /hints", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</app.js>; rel=preload")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Write([]byte("done"))
+	})
+	mux.HandleFunc("GET /empty", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	srv := startServer(t, FromHTTP(mux))
+	base := "http://" + srv.Addr().String()
+
+	t.Run("Fixed length", func(t *testing.T) {
+		res, err := http.Get(base + "/hello/world?q=1")
+		require.NoError(t, err)
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "hello world", string(body))
+		assert.Equal(t, int64(11), res.ContentLength)
+		assert.Equal(t, "1", res.Header.Get("X-Query"))
+		assert.Equal(t, []string{"a=1", "b=2"}, res.Header.Values("Set-Cookie"))
+	})
+
+	t.Run("Chunked request body with trailers", func(t *testing.T) {
+		req, err := http.NewRequest("POST", base+"/echo", io.MultiReader(strings.NewReader("abc"), strings.NewReader("def")))
+		require.NoError(t, err)
+		req.ContentLength = -1
+		req.Trailer = http.Header{"X-Sum": {"42"}}
+
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+
+		assert.Equal(t, http.StatusCreated, res.StatusCode)
+		assert.Equal(t, "abcdef42", string(body))
+		assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	})
+
+	t.Run("Streaming with trailers", func(t *testing.T) {
+		res, err := http.Get(base + "/stream")
+		require.NoError(t, err)
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+
+		assert.Equal(t, "0;1;2;", string(body))
+		assert.Equal(t, "3", res.Trailer.Get("X-Count"))
+		assert.Equal(t, "yes", res.Trailer.Get("X-Late"))
+		assert.Equal(t, "no", res.Trailer.Get("X-Undeclared"))
+		assert.Empty(t, res.Trailer.Get("Content-Length"))
+		assert.Equal(t, "text/plain; charset=utf-8", res.Header.Get("Content-Type"))
+	})
+
+	t.Run("Early hints", func(t *testing.T) {
+		var hints []int
+		trace := &httptrace.ClientTrace{
+			Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+				hints = append(hints, code)
+				assert.Equal(t, "</app.js>; rel=preload", header.Get("Link"))
+				return nil
+			},
+		}
+		req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), "GET", base+"/hints", nil)
+		require.NoError(t, err)
+
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+
+		assert.Equal(t, []int{http.StatusEarlyHints}, hints)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "done", string(body))
+	})
+
+	t.Run("No body", func(t *testing.T) {
+		res, err := http.Get(base + "/empty")
+		require.NoError(t, err)
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+
+		assert.Equal(t, http.StatusNoContent, res.StatusCode)
+		assert.Empty(t, body)
+
+		// the connection is still usable
+		res, err = http.Get(base + "/hello/again")
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		res, err := http.Get(base + "/nope")
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	})
+}
+
+func TestToHTTP(t *testing.T) {
+	h := ToHTTP(func(w *response.Writer, req *request.Request) {
+		switch req.Path() {
+		case "/stream":
+			h := headers.NewHeaders()
+			h.Set("Content-Type", "text/plain")
+			h.Set("Transfer-Encoding", "chunked")
+			h.Set("Trailer", "X-Count")
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*h)
+			w.WriteChunkedBody([]byte("one;"))
+			w.WriteChunkedBody([]byte("two;"))
+			w.WriteChunkedBodyDone()
+
+			t := headers.NewHeaders()
+			t.Set("X-Count", "2")
+			w.WriteTrailers(t)
+		case "/echo":
+			body, _ := io.ReadAll(req.Body)
+			body = append(body, req.Headers.Get("X-Test")...)
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+			w.WriteBody(body)
+		default:
+			WriteError(w, req, Errorf(response.StatusNotFound, "no %s here", req.Path()))
+		}
+	})
+
+	t.Run("httptest recorder", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/echo", strings.NewReader("abc"))
+		req.Header.Set("X-Test", "!")
+		h.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "abc!", rec.Body.String())
+		assert.Equal(t, "4", rec.Header().Get("Content-Length"))
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/missing", nil))
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, "no /missing here\n", rec.Body.String())
+	})
+
+	t.Run("Real server with trailers", func(t *testing.T) {
+		ts := httptest.NewServer(h)
+		defer ts.Close()
+
+		res, err := http.Get(ts.URL + "/stream")
+		require.NoError(t, err)
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+
+		assert.Equal(t, "one;two;", string(body))
+		assert.Equal(t, "2", res.Trailer.Get("X-Count"))
+		assert.Empty(t, res.Header.Get("Connection"))
+	})
+}
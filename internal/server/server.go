@@ -1,10 +1,17 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sync"
 	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/kalim-Asim/http-server/internal/headers"
 	"github.com/kalim-Asim/http-server/internal/request"
 	"github.com/kalim-Asim/http-server/internal/response"
 )
@@ -13,6 +20,46 @@ type Server struct {
 	listener net.Listener
 	isClosed atomic.Bool
 	handler  Handler
+	limits   request.Limits
+	casing   headers.Casing
+
+	// applied as connection deadlines, zero means no timeout
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	timeouts timeoutCounters
+
+	// bounds each request context, zero means no deadline
+	RequestTimeout time.Duration
+
+	mu    sync.Mutex
+	conns map[net.Conn]connState // open connections, for Shutdown
+
+	// parent of every request context, cancelled by Close
+	// and when Shutdown gives up waiting
+	baseCtx    context.Context
+	cancelBase context.CancelFunc
+}
+
+// Option configures a Server before it starts accepting connections
+type Option func(*Server)
+
+// WithHeaderCasing sets how response field names are written,
+// headers.CaseCanonical by default
+func WithHeaderCasing(casing headers.Casing) Option {
+	return func(s *Server) {
+		s.casing = casing
+	}
+}
+
+// WithLimits bounds request line, header and body sizes,
+// zero fields keep the request.DefaultLimits value
+func WithLimits(limits request.Limits) Option {
+	return func(s *Server) {
+		s.limits = limits
+	}
 }
 
 //a proper status code and error message
@@ -21,13 +68,31 @@ type HandlerError struct {
 	Message []byte 
 }
 
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("%d %s", e.StatusCode, e.Message)
+}
+
+// Errorf builds a HandlerError with a formatted message
+func Errorf(status response.StatusCode, format string, args ...any) *HandlerError {
+	return &HandlerError{
+		StatusCode: status,
+		Message:    fmt.Appendf(nil, format, args...),
+	}
+}
+
 type Handler func(w *response.Writer, req *request.Request)
 
+// ErrorHandler is a Handler that may fail. A returned error is turned
+// into the response by HandleErrors, see WriteError.
+type ErrorHandler func(w *response.Writer, req *request.Request) error
+
 // stops the server by closing the underlying net.Listener. 
 // Setting the atomic boolean ensures the listen() loop 
-// knows the shutdown was intentional
+// knows the shutdown was intentional.
+// Open connections are left alone, use Shutdown to drain them
 func (s *Server) Close() error {
 	s.isClosed.Store(true) // Mark as closed before closing the listener
+	s.cancelBase()
 	if s.listener != nil {
 		return s.listener.Close()
 	}
@@ -37,19 +102,195 @@ func (s *Server) Close() error {
 // manages the lifecycle of a single connection. 
 // It is critical to use defer conn.Close() to ensure 
 // the TCP connection is released regardless of how the function exits. 
-func (s *Server) handle(conn io.ReadWriteCloser) {
+// The connection is kept open for further requests (HTTP/1.1 keep-alive)
+// until the client or the handler asks for "Connection: close".
+func (s *Server) handle(conn net.Conn) {
 	defer conn.Close()
+	defer s.untrackConn(conn)
 
-	responseWriter := response.NewWriter(conn) 
-	r, err := request.RequestFromReader(conn)
+	// request contexts end with the connection at the latest
+	connCtx, cancelConn := context.WithCancel(s.baseCtx)
+	defer cancelConn()
 
-	if err != nil {
-		responseWriter.WriteStatusLine(response.StatusBadRequest)
-		responseWriter.WriteHeaders(*response.GetDefaultHeaders(0))
-		return 
+	reader := request.NewReaderLimits(conn, s.limits)
+	for first := true; ; first = false {
+		// wait for the next request. The header and read clocks of the
+		// first one run since accept, later ones start at their first byte
+		var readDeadline time.Time
+		if first {
+			readDeadline = deadline(s.ReadTimeout)
+			conn.SetReadDeadline(earliest(deadline(s.ReadHeaderTimeout), readDeadline))
+		} else {
+			conn.SetReadDeadline(deadline(s.idleTimeout()))
+		}
+		if err := reader.Wait(); err != nil {
+			if isTimeout(err) {
+				if first {
+					s.countHeaderTimeout()
+				} else {
+					s.timeouts.idle.Add(1)
+				}
+			}
+			return
+		}
+		if !s.setConnState(conn, stateActive) {
+			// closed by Shutdown while idle
+			return
+		}
+
+		if !first {
+			readDeadline = deadline(s.ReadTimeout)
+			conn.SetReadDeadline(earliest(deadline(s.ReadHeaderTimeout), readDeadline))
+		}
+
+		responseWriter := response.NewWriter(conn) 
+		r, err := reader.ReadRequest()
+		if err != nil {
+			if isTimeout(err) {
+				s.countHeaderTimeout()
+			}
+			// the last response's write deadline may have passed
+			conn.SetWriteDeadline(deadline(s.WriteTimeout))
+			s.writeParseError(responseWriter, err)
+			return 
+		}
+
+		conn.SetReadDeadline(readDeadline)
+		conn.SetWriteDeadline(deadline(s.WriteTimeout))
+
+		if !r.KeepAlive() {
+			responseWriter.SetKeepAlive(false)
+		}
+		responseWriter.CloseIf(s.isClosed.Load)
+		responseWriter.SetMethod(r.RequestLine.Method)
+		responseWriter.SetHeaderCasing(s.casing)
+
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if s.RequestTimeout > 0 {
+			ctx, cancel = context.WithTimeout(connCtx, s.RequestTimeout)
+		} else {
+			ctx, cancel = context.WithCancel(connCtx)
+		}
+		r = r.WithContext(ctx)
+
+		stopWatch := watchDisconnect(conn, reader, r, cancel)
+		s.handler(responseWriter, r)
+		stopWatch(readDeadline)
+		cancel()
+
+		if isTimeout(responseWriter.Err()) {
+			s.timeouts.write.Add(1)
+			return
+		}
+		switch responseWriter.State() {
+		case response.StateStatus:
+			// a handler that gave up on a body over the limit or
+			// malformed without answering: the body error is the answer
+			var reqErr *request.Error
+			if err := r.Body.Close(); errors.As(err, &reqErr) {
+				s.writeParseError(responseWriter, err)
+				return
+			}
+		case response.StateHeaders:
+			// the status line is only sent with the headers,
+			// headers that failed their checks left nothing on the wire
+			WriteError(responseWriter, r, errors.New("handler returned before sending its headers"))
+		}
+		if !finishResponse(responseWriter) {
+			return
+		}
+
+		// drain what the handler did not read, the next request
+		// starts right after this body; too much left unread
+		// closes the connection instead
+		if err := r.Body.Close(); err != nil {
+			if isTimeout(err) {
+				s.timeouts.read.Add(1)
+			}
+			return
+		}
+
+		if !responseWriter.KeepAlive() {
+			return
+		}
+		if !s.setConnState(conn, stateIdle) {
+			// shutting down, no further requests
+			return
+		}
 	}
-	
-	s.handler(responseWriter, r)
+}
+
+// a handler that returned halfway leaves the client waiting for the rest.
+// Only missing trailers can be added, the body itself is over by then;
+// anything else is unusable and the connection has to be closed
+func finishResponse(w *response.Writer) bool {
+	switch w.State() {
+	case response.StateDone:
+		return true
+	case response.StateTrailers:
+		return w.WriteTrailers(headers.NewHeaders()) == nil
+	}
+	return w.Complete() && w.KeepAlive()
+}
+
+// long gone, setting it as deadline interrupts a blocked read
+var aLongTimeAgo = time.Unix(1, 0)
+
+// runs a background read while the handler works, so a client that hangs
+// up cancels the request context. Only requests without a body are watched,
+// for the others the read would race the handler reading the body.
+// The returned func stops the read and restores readDeadline.
+func watchDisconnect(conn net.Conn, reader *request.Reader, r *request.Request, cancel context.CancelFunc) func(readDeadline time.Time) {
+	if r.Body != request.NoBody {
+		return func(time.Time) {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := reader.ReadAhead()
+		if errors.Is(err, io.EOF) || isDisconnect(err) {
+			cancel()
+		}
+	}()
+
+	return func(readDeadline time.Time) {
+		conn.SetReadDeadline(aLongTimeAgo)
+		<-done
+		conn.SetReadDeadline(readDeadline)
+	}
+}
+
+// answers a request that could not be parsed with the status its
+// error implies and a one line reason, then the connection is closed
+func (s *Server) writeParseError(w *response.Writer, err error) {
+	if isDisconnect(err) {
+		// nobody is listening anymore
+		return
+	}
+
+	body := []byte(request.ReasonFor(err) + "\n")
+	w.SetHeaderCasing(s.casing)
+	w.SetKeepAlive(false)
+	w.WriteStatusLine(response.StatusCode(request.StatusFor(err)))
+	w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+// reports whether err means the client went away,
+// either mid-request or by resetting the connection
+func isDisconnect(err error) bool {
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// Addr returns the address the server listens on,
+// handy when it was started on port 0
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
 }
 
 // runs the acceptance loop. By checking the atomic.Bool, 
@@ -67,23 +308,35 @@ func (s *Server) listen() {
 			continue
 		}
 
+		s.trackConn(conn)
 		go s.handle(conn)
 	}
 }
 
-func Serve(port int, handler Handler) (*Server, error) {
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
 	addr := fmt.Sprintf(":%d", port)
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 			return nil, err
 	}
 
+	return serve(ln, handler, opts...), nil
+}
+
+// starts the accept loop on an open listener,
+// plain TCP or TLS makes no difference past this point
+func serve(ln net.Listener, handler Handler, opts ...Option) *Server {
 	srv := &Server{
 		listener: ln,
 		handler: handler,
+		limits: request.DefaultLimits,
+	}
+	srv.baseCtx, srv.cancelBase = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(srv)
 	}
 
 	go srv.listen()
 
-	return srv, nil
+	return srv
 }
\ No newline at end of file
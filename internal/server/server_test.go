@@ -0,0 +1,424 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kalim-Asim/http-server/internal/headers"
+	"github.com/kalim-Asim/http-server/internal/request"
+	"github.com/kalim-Asim/http-server/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// starts a server on a free port, closed when the test ends
+func startServer(t *testing.T, handler Handler, opts ...Option) *Server {
+	t.Helper()
+	srv, err := Serve(0, handler, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func dial(t *testing.T, srv *Server) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// waits until the server closed conn, returning what it sent
+func readAll(t *testing.T, conn net.Conn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	b, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestKeepAlive(t *testing.T) {
+	srv := startServer(t, writeOK)
+	conn := dial(t, srv)
+	br := bufio.NewReader(conn)
+
+	for range 3 {
+		_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+		require.NoError(t, err)
+		res, err := http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(res.Body)
+		assert.Equal(t, "ok", string(body))
+	}
+
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	res, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.True(t, res.Close)
+}
+
+func TestHeaderCasing(t *testing.T) {
+	srv := startServer(t, writeOK, WithHeaderCasing(headers.CaseLower))
+
+	conn := dial(t, srv)
+	conn.Write([]byte("GET / HTTP/1.1\r\nConnection: close\r\n\r\n"))
+	res := readAll(t, conn)
+	assert.Contains(t, res, "content-length: 2\r\n")
+
+	// parse errors are written the same way
+	conn = dial(t, srv)
+	conn.Write([]byte("GET / HTTP/2.0\r\n\r\n"))
+	res = readAll(t, conn)
+	assert.Contains(t, res, "HTTP/1.1 505 ")
+	assert.Contains(t, res, "content-length: ")
+	assert.Contains(t, res, "connection: close\r\n")
+	assert.NotContains(t, res, "Content-Length")
+}
+
+func TestBodyTooLarge(t *testing.T) {
+	srv := startServer(t, func(w *response.Writer, req *request.Request) {
+		if _, err := io.ReadAll(req.Body); err != nil {
+			return
+		}
+		writeOK(w, req)
+	}, WithLimits(request.Limits{MaxBodyBytes: 4}))
+
+	// Test: declared up front
+	conn := dial(t, srv)
+	conn.Write([]byte("POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello"))
+	assert.Contains(t, readAll(t, conn), "HTTP/1.1 413 ")
+
+	// Test: a chunked body only tells on the way
+	conn = dial(t, srv)
+	conn.Write([]byte("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"3\r\nabc\r\n3\r\ndef\r\n0\r\n\r\n"))
+	res := readAll(t, conn)
+	assert.Contains(t, res, "HTTP/1.1 413 ")
+	assert.Contains(t, res, "body too large")
+
+	// Test: within the limit
+	conn = dial(t, srv)
+	conn.Write([]byte("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n" +
+		"3\r\nabc\r\n0\r\n\r\n"))
+	assert.Contains(t, readAll(t, conn), "HTTP/1.1 200 OK\r\n")
+}
+
+func TestInvalidHeaders(t *testing.T) {
+	srv := startServer(t, func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Add("Location", "/next\r\nSet-Cookie: admin=1")
+		w.WriteStatusLine(response.StatusFound)
+		w.WriteHeaders(*h)
+	})
+
+	conn := dial(t, srv)
+	conn.Write([]byte("GET / HTTP/1.1\r\nConnection: close\r\n\r\n"))
+	res := readAll(t, conn)
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 500 Internal Server Error\r\n"), res)
+	assert.NotContains(t, res, "302")
+	assert.NotContains(t, res, "Set-Cookie")
+}
+
+func TestTimeouts(t *testing.T) {
+	t.Run("Slow headers get 408", func(t *testing.T) {
+		srv := startServer(t, writeOK, WithReadHeaderTimeout(50*time.Millisecond))
+		conn := dial(t, srv)
+
+		conn.Write([]byte("GET / HTTP/1.1\r\nHost: "))
+		res := readAll(t, conn)
+
+		assert.Contains(t, res, "HTTP/1.1 408 Request Timeout\r\n")
+		assert.Equal(t, uint64(1), srv.Timeouts().ReadHeader)
+	})
+
+	t.Run("Silent connection is dropped", func(t *testing.T) {
+		srv := startServer(t, writeOK, WithReadHeaderTimeout(50*time.Millisecond))
+		conn := dial(t, srv)
+
+		assert.Empty(t, readAll(t, conn))
+		assert.Equal(t, uint64(1), srv.Timeouts().ReadHeader)
+	})
+
+	t.Run("Silent connection is dropped by the read timeout", func(t *testing.T) {
+		srv := startServer(t, writeOK, WithReadTimeout(50*time.Millisecond))
+		conn := dial(t, srv)
+
+		assert.Empty(t, readAll(t, conn))
+		assert.Equal(t, uint64(1), srv.Timeouts().Read)
+	})
+
+	t.Run("Idle keep-alive connection is closed", func(t *testing.T) {
+		srv := startServer(t, writeOK, WithIdleTimeout(50*time.Millisecond))
+		conn := dial(t, srv)
+
+		conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		res := readAll(t, conn)
+
+		assert.Contains(t, res, "HTTP/1.1 200 OK\r\n")
+		assert.Equal(t, uint64(1), srv.Timeouts().Idle)
+	})
+
+	t.Run("Slow body", func(t *testing.T) {
+		srv := startServer(t, func(w *response.Writer, req *request.Request) {
+			io.ReadAll(req.Body)
+			writeOK(w, req)
+		}, WithReadTimeout(50*time.Millisecond))
+		conn := dial(t, srv)
+
+		conn.Write([]byte("POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\nabc"))
+		readAll(t, conn)
+
+		assert.Equal(t, uint64(1), srv.Timeouts().Read)
+	})
+
+	t.Run("Bad request after the last write deadline passed", func(t *testing.T) {
+		srv := startServer(t, writeOK, WithWriteTimeout(50*time.Millisecond))
+		conn := dial(t, srv)
+		br := bufio.NewReader(conn)
+
+		conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		res, err := http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		io.ReadAll(res.Body)
+
+		time.Sleep(150 * time.Millisecond)
+		conn.Write([]byte("GET / HTTP/2.0\r\n\r\n"))
+		res, err = http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 505, res.StatusCode)
+	})
+
+	t.Run("Client not reading the response", func(t *testing.T) {
+		big := make([]byte, 64<<20)
+		srv := startServer(t, func(w *response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*response.GetDefaultHeaders(len(big)))
+			w.WriteBody(big)
+		}, WithWriteTimeout(50*time.Millisecond))
+		conn := dial(t, srv)
+
+		conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+
+		assert.Eventually(t, func() bool {
+			return srv.Timeouts().Write == 1
+		}, 2*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestShutdown(t *testing.T) {
+	t.Run("Waits for active requests and closes idle ones", func(t *testing.T) {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		srv := startServer(t, func(w *response.Writer, req *request.Request) {
+			if req.RequestLine.RequestTarget == "/slow" {
+				close(started)
+				<-release
+			}
+			writeOK(w, req)
+		})
+
+		idle := dial(t, srv)
+		idle.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		_, err := http.ReadResponse(bufio.NewReader(idle), nil)
+		require.NoError(t, err)
+
+		active := dial(t, srv)
+		active.Write([]byte("GET /slow HTTP/1.1\r\n\r\n"))
+		<-started
+
+		done := make(chan struct{})
+		var forced int
+		go func() {
+			forced, err = srv.Shutdown(context.Background())
+			close(done)
+		}()
+
+		// the idle connection goes away right away
+		assert.Empty(t, readAll(t, idle))
+
+		select {
+		case <-done:
+			t.Fatal("Shutdown returned while a request was active")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(release)
+		res := readAll(t, active)
+		<-done
+
+		require.NoError(t, err)
+		assert.Equal(t, 0, forced)
+		assert.Contains(t, res, "HTTP/1.1 200 OK\r\n")
+		assert.Contains(t, res, "Connection: close\r\n")
+
+		// no longer accepting
+		_, err = net.Dial("tcp", srv.Addr().String())
+		assert.Error(t, err)
+	})
+
+	t.Run("Forcibly closes what is left at the deadline", func(t *testing.T) {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		defer close(release)
+		srv := startServer(t, func(w *response.Writer, req *request.Request) {
+			close(started)
+			<-release
+		})
+
+		conn := dial(t, srv)
+		conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		forced, err := srv.Shutdown(ctx)
+
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 1, forced)
+		assert.Empty(t, readAll(t, conn))
+	})
+}
+
+func TestRequestContext(t *testing.T) {
+	// runs until the request context ends, reporting why
+	waitForCancel := func(errs chan<- error) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			select {
+			case <-req.Context().Done():
+				errs <- req.Context().Err()
+			case <-time.After(2 * time.Second):
+				errs <- nil
+			}
+		}
+	}
+
+	t.Run("Client disconnect", func(t *testing.T) {
+		errs := make(chan error, 1)
+		srv := startServer(t, waitForCancel(errs))
+		conn := dial(t, srv)
+
+		conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		time.Sleep(20 * time.Millisecond)
+		conn.Close()
+
+		assert.ErrorIs(t, <-errs, context.Canceled)
+	})
+
+	t.Run("Request deadline", func(t *testing.T) {
+		errs := make(chan error, 1)
+		srv := startServer(t, waitForCancel(errs), WithRequestTimeout(30*time.Millisecond))
+		conn := dial(t, srv)
+
+		conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+
+		assert.ErrorIs(t, <-errs, context.DeadlineExceeded)
+	})
+
+	t.Run("Forced shutdown", func(t *testing.T) {
+		errs := make(chan error, 1)
+		srv := startServer(t, waitForCancel(errs))
+		conn := dial(t, srv)
+		conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		time.Sleep(20 * time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+		defer cancel()
+		srv.Shutdown(ctx)
+
+		assert.ErrorIs(t, <-errs, context.Canceled)
+	})
+
+	t.Run("Pipelined request is not lost", func(t *testing.T) {
+		srv := startServer(t, func(w *response.Writer, req *request.Request) {
+			time.Sleep(20 * time.Millisecond)
+			assert.NoError(t, req.Context().Err())
+			writeOK(w, req)
+		})
+		conn := dial(t, srv)
+
+		conn.Write([]byte("GET /1 HTTP/1.1\r\n\r\nGET /2 HTTP/1.1\r\nConnection: close\r\n\r\n"))
+		res := readAll(t, conn)
+
+		assert.Equal(t, 2, strings.Count(res, "HTTP/1.1 200 OK\r\n"))
+	})
+
+	t.Run("Middleware attaches values", func(t *testing.T) {
+		ids := make(chan string, 1)
+		srv := startServer(t, Chain(func(w *response.Writer, req *request.Request) {
+			ids <- RequestIDFromContext(req.Context())
+			writeOK(w, req)
+		}, RequestID()))
+		conn := dial(t, srv)
+
+		conn.Write([]byte("GET / HTTP/1.1\r\nX-Request-Id: trace-1\r\nConnection: close\r\n\r\n"))
+		readAll(t, conn)
+
+		assert.Equal(t, "trace-1", <-ids)
+	})
+}
+
+func TestIncompleteResponse(t *testing.T) {
+	srv := startServer(t, func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/short":
+			// promises more than it sends
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*response.GetDefaultHeaders(10))
+			w.WriteBody([]byte("half"))
+		case "/no-trailers":
+			h := headers.NewHeaders()
+			h.Set("Transfer-Encoding", "chunked")
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*h)
+			w.WriteChunkedBody([]byte("ok"))
+			w.WriteChunkedBodyDone()
+		}
+	})
+
+	t.Run("Missing trailers are added", func(t *testing.T) {
+		conn := dial(t, srv)
+		br := bufio.NewReader(conn)
+		for range 2 {
+			conn.Write([]byte("GET /no-trailers HTTP/1.1\r\n\r\n"))
+			res, err := http.ReadResponse(br, nil)
+			require.NoError(t, err)
+			body, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			assert.Equal(t, "ok", string(body))
+		}
+	})
+
+	t.Run("Short body closes the connection", func(t *testing.T) {
+		conn := dial(t, srv)
+		conn.Write([]byte("GET /short HTTP/1.1\r\n\r\n"))
+		res := readAll(t, conn)
+		assert.True(t, strings.HasSuffix(res, "\r\n\r\nhalf"))
+	})
+}
+
+func TestHEAD(t *testing.T) {
+	srv := startServer(t, writeOK)
+	conn := dial(t, srv)
+	br := bufio.NewReader(conn)
+
+	conn.Write([]byte("HEAD / HTTP/1.1\r\n\r\nGET / HTTP/1.1\r\n\r\n"))
+
+	res, err := http.ReadResponse(br, &http.Request{Method: "HEAD"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), res.ContentLength)
+	res.Body.Close()
+
+	// nothing of the HEAD body is on the wire, the next response follows
+	res, err = http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(res.Body)
+	assert.Equal(t, "ok", string(body))
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+type connState int
+
+const (
+	// waiting for the next request (or the first one)
+	stateIdle connState = iota
+	// a request is being read or handled
+	stateActive
+)
+
+// how often Shutdown looks for connections that went idle
+const shutdownPollInterval = 10 * time.Millisecond
+
+func (s *Server) trackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]connState)
+	}
+	s.conns[conn] = stateIdle
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+// moves conn to state. It returns false when the connection is no longer
+// tracked, i.e. Shutdown already closed it, or when it went idle after
+// Shutdown started: the caller should stop serving it.
+func (s *Server) setConnState(conn net.Conn, state connState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.conns[conn]; !ok {
+		return false
+	}
+	s.conns[conn] = state
+	return state == stateActive || !s.isClosed.Load()
+}
+
+// closes every idle connection and returns how many are still active
+func (s *Server) closeIdleConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, state := range s.conns {
+		if state == stateIdle {
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+	return len(s.conns)
+}
+
+// closes whatever is left and returns how many that were
+func (s *Server) closeAllConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.conns)
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+	return n
+}
+
+// Shutdown stops accepting, closes idle keep-alive connections and waits
+// for active ones to finish their current response. When ctx is done
+// first the remaining connections are closed forcibly and their request
+// contexts cancelled; their number is returned together with ctx.Err().
+func (s *Server) Shutdown(ctx context.Context) (int, error) {
+	s.isClosed.Store(true)
+	s.listener.Close()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if s.closeIdleConns() == 0 {
+			return 0, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			s.cancelBase()
+			return s.closeAllConns(), ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
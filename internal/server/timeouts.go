@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// WithReadHeaderTimeout bounds the time from the first byte of a request
+// (or from accept, for the first request) until its headers are parsed
+func WithReadHeaderTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.ReadHeaderTimeout = d
+	}
+}
+
+// WithReadTimeout bounds reading a whole request, body included, for the
+// first request counted from accept
+func WithReadTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.ReadTimeout = d
+	}
+}
+
+// WithWriteTimeout bounds writing the response, counted from the
+// moment the request headers are parsed
+func WithWriteTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.WriteTimeout = d
+	}
+}
+
+// WithIdleTimeout bounds how long a keep-alive connection may wait for
+// its next request. Without it ReadTimeout is used, like net/http does.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.IdleTimeout = d
+	}
+}
+
+// WithRequestTimeout puts a deadline on every request context,
+// handlers see it through req.Context()
+func WithRequestTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.RequestTimeout = d
+	}
+}
+
+// TimeoutStats counts connections dropped by each timeout
+type TimeoutStats struct {
+	ReadHeader uint64
+	Read       uint64
+	Write      uint64
+	Idle       uint64
+}
+
+type timeoutCounters struct {
+	readHeader atomic.Uint64
+	read       atomic.Uint64
+	write      atomic.Uint64
+	idle       atomic.Uint64
+}
+
+// Timeouts returns how many times each timeout expired so far
+func (s *Server) Timeouts() TimeoutStats {
+	return TimeoutStats{
+		ReadHeader: s.timeouts.readHeader.Load(),
+		Read:       s.timeouts.read.Load(),
+		Write:      s.timeouts.write.Load(),
+		Idle:       s.timeouts.idle.Load(),
+	}
+}
+
+// a deadline hit before the headers were parsed, counted against the
+// timeout that set it: the shorter of ReadHeaderTimeout and ReadTimeout
+func (s *Server) countHeaderTimeout() {
+	if s.ReadHeaderTimeout > 0 && (s.ReadTimeout == 0 || s.ReadHeaderTimeout <= s.ReadTimeout) {
+		s.timeouts.readHeader.Add(1)
+	} else {
+		s.timeouts.read.Add(1)
+	}
+}
+
+func (s *Server) idleTimeout() time.Duration {
+	if s.IdleTimeout != 0 {
+		return s.IdleTimeout
+	}
+	return s.ReadTimeout
+}
+
+// deadline d from now, the zero time (no deadline) for d == 0
+func deadline(d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
+
+// the earlier of two deadlines, the zero time meaning none
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || !b.IsZero() && b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
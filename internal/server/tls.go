@@ -0,0 +1,237 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ServeTLS is Serve over TLS with a single certificate loaded from disk.
+// The files are watched, a renewed certificate is picked up by the next
+// handshake without a restart.
+func ServeTLS(port int, handler Handler, certFile, keyFile string, opts ...Option) (*Server, error) {
+	store := NewCertStore()
+	if err := store.Add(certFile, keyFile); err != nil {
+		return nil, err
+	}
+	return ServeTLSConfig(port, handler, store.TLSConfig(), opts...)
+}
+
+// ServeTLSConfig is Serve over TLS with a caller provided config, e.g.
+// one built by CertStore.TLSConfig for several certificates.
+// The request parser and response writer run on the decrypted stream.
+func ServeTLSConfig(port int, handler Handler, config *tls.Config, opts ...Option) (*Server, error) {
+	if config == nil || len(config.Certificates) == 0 &&
+		config.GetCertificate == nil && config.GetConfigForClient == nil {
+		return nil, fmt.Errorf("tls config without certificates")
+	}
+
+	addr := fmt.Sprintf(":%d", port)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return serve(tls.NewListener(ln, config), handler, opts...), nil
+}
+
+// CertStore serves certificates loaded from disk. The certificate for a
+// handshake is picked by the SNI server name, falling back to the first
+// one added. Files that changed on disk are reloaded on the next handshake
+// after CheckInterval, a broken file keeps the previous certificate.
+// Handshakes only read the current certificates, at most one of them per
+// entry and interval checks the files.
+type CertStore struct {
+	// how often the files are checked for changes, 0 checks on every handshake
+	CheckInterval time.Duration
+
+	mu      sync.RWMutex // guards entries, not what they hold
+	entries []*certEntry
+}
+
+type certEntry struct {
+	certFile, keyFile string
+
+	current   atomic.Pointer[loadedCert]
+	lastCheck atomic.Int64 // unix nanoseconds
+	reloadMu  sync.Mutex   // one reload of the files at a time
+}
+
+// a certificate as loaded, replaced as a whole on reload
+type loadedCert struct {
+	cert    *tls.Certificate
+	names   []string // DNS names of the leaf, lower case
+	modTime time.Time
+}
+
+func NewCertStore() *CertStore {
+	return &CertStore{
+		CheckInterval: time.Second,
+	}
+}
+
+// Add loads a certificate/key pair and serves it
+// for the DNS names the certificate is valid for
+func (c *CertStore) Add(certFile, keyFile string) error {
+	entry := &certEntry{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := entry.load(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = append(c.entries, entry)
+	return nil
+}
+
+// TLSConfig returns a config that takes its certificates from c
+func (c *CertStore) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.GetCertificate,
+	}
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (c *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	if len(c.entries) == 0 {
+		c.mu.RUnlock()
+		return nil, fmt.Errorf("no certificates")
+	}
+	entry := c.match(strings.ToLower(strings.TrimSuffix(hello.ServerName, ".")))
+	c.mu.RUnlock()
+
+	entry.checkFiles(c.CheckInterval)
+	return entry.current.Load().cert, nil
+}
+
+// Reload reloads every certificate whose files changed on disk. An entry
+// that fails keeps its certificate, the errors of all of them are returned.
+func (c *CertStore) Reload() error {
+	c.mu.RLock()
+	entries := slices.Clone(c.entries)
+	c.mu.RUnlock()
+
+	var errs []error
+	for _, entry := range entries {
+		entry.reloadMu.Lock()
+		err := entry.reloadIfChanged()
+		entry.reloadMu.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", entry.certFile, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// exact name first, then a wildcard covering it, then the default
+func (c *CertStore) match(serverName string) *certEntry {
+	if serverName != "" {
+		for _, entry := range c.entries {
+			if slices.Contains(entry.current.Load().names, serverName) {
+				return entry
+			}
+		}
+
+		if _, parent, ok := strings.Cut(serverName, "."); ok {
+			for _, entry := range c.entries {
+				if slices.Contains(entry.current.Load().names, "*."+parent) {
+					return entry
+				}
+			}
+		}
+	}
+	return c.entries[0]
+}
+
+// reloads the files if interval passed since the last check. Only the
+// handshake that claims the check looks at the disk, a reload already
+// running is not waited for; the others go on with the current certificate
+func (e *certEntry) checkFiles(interval time.Duration) {
+	last := e.lastCheck.Load()
+	if time.Since(time.Unix(0, last)) < interval ||
+		!e.lastCheck.CompareAndSwap(last, time.Now().UnixNano()) {
+		return
+	}
+	if !e.reloadMu.TryLock() {
+		return
+	}
+	defer e.reloadMu.Unlock()
+	e.reloadIfChanged()
+}
+
+func (e *certEntry) load() error {
+	modTime, err := e.filesModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(e.certFile, e.keyFile)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	cert.Leaf = leaf
+
+	names := []string{}
+	for _, name := range leaf.DNSNames {
+		names = append(names, strings.ToLower(name))
+	}
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = append(names, strings.ToLower(leaf.Subject.CommonName))
+	}
+
+	e.current.Store(&loadedCert{
+		cert:    &cert,
+		names:   names,
+		modTime: modTime,
+	})
+	e.lastCheck.Store(time.Now().UnixNano())
+	return nil
+}
+
+// called with reloadMu held
+func (e *certEntry) reloadIfChanged() error {
+	e.lastCheck.Store(time.Now().UnixNano())
+
+	modTime, err := e.filesModTime()
+	if err != nil {
+		return err
+	}
+	if modTime.Equal(e.current.Load().modTime) {
+		return nil
+	}
+	return e.load()
+}
+
+// the later modification time of the two files
+func (e *certEntry) filesModTime() (time.Time, error) {
+	certInfo, err := os.Stat(e.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyInfo, err := os.Stat(e.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
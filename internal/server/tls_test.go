@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var serial int64
+
+// writes a fresh self-signed certificate for names into dir,
+// returning the file paths and the parsed certificate
+func writeCert(t *testing.T, dir, file string, names ...string) (string, string, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: names[0]},
+		DNSNames:              names,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, file+".crt")
+	keyFile := filepath.Join(dir, file+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	return certFile, keyFile, cert
+}
+
+// does a request over TLS, returning the body and the served certificate
+func getTLS(t *testing.T, srv *Server, serverName string, roots *x509.CertPool) (string, *x509.Certificate) {
+	t.Helper()
+
+	conn, err := tls.Dial("tcp", srv.Addr().String(), &tls.Config{
+		ServerName: serverName,
+		RootCAs:    roots,
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: " + serverName + "\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	return string(body), conn.ConnectionState().PeerCertificates[0]
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, cert := writeCert(t, dir, "localhost", "localhost")
+
+	srv, err := ServeTLS(0, writeOK, certFile, keyFile)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	body, served := getTLS(t, srv, "localhost", roots)
+	assert.Equal(t, "ok", body)
+	assert.Equal(t, cert.SerialNumber, served.SerialNumber)
+}
+
+func TestServeTLSErrors(t *testing.T) {
+	_, err := ServeTLS(0, writeOK, "missing.crt", "missing.key")
+	assert.Error(t, err)
+
+	_, err = ServeTLSConfig(0, writeOK, &tls.Config{})
+	assert.Error(t, err)
+}
+
+func TestCertStoreSNI(t *testing.T) {
+	dir := t.TempDir()
+	store := NewCertStore()
+
+	roots := x509.NewCertPool()
+	certs := map[string]*x509.Certificate{}
+	for _, name := range []string{"a.test", "b.test", "*.wild.test"} {
+		certFile, keyFile, cert := writeCert(t, dir, name, name)
+		require.NoError(t, store.Add(certFile, keyFile))
+		roots.AddCert(cert)
+		certs[name] = cert
+	}
+
+	srv, err := ServeTLSConfig(0, writeOK, store.TLSConfig())
+	require.NoError(t, err)
+	defer srv.Close()
+
+	_, served := getTLS(t, srv, "b.test", roots)
+	assert.Equal(t, certs["b.test"].SerialNumber, served.SerialNumber)
+
+	_, served = getTLS(t, srv, "a.test", roots)
+	assert.Equal(t, certs["a.test"].SerialNumber, served.SerialNumber)
+
+	_, served = getTLS(t, srv, "api.wild.test", roots)
+	assert.Equal(t, certs["*.wild.test"].SerialNumber, served.SerialNumber)
+
+	// unknown names get the first certificate
+	cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.test"})
+	require.NoError(t, err)
+	assert.Equal(t, certs["a.test"].SerialNumber, cert.Leaf.SerialNumber)
+}
+
+func TestCertStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, oldCert := writeCert(t, dir, "site", "localhost")
+
+	store := NewCertStore()
+	store.CheckInterval = 0
+	require.NoError(t, store.Add(certFile, keyFile))
+
+	srv, err := ServeTLSConfig(0, writeOK, store.TLSConfig())
+	require.NoError(t, err)
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(oldCert)
+	_, served := getTLS(t, srv, "localhost", roots)
+	assert.Equal(t, oldCert.SerialNumber, served.SerialNumber)
+
+	// renew on disk, push the mtime forward in case the
+	// filesystem clock is too coarse to tell the writes apart
+	_, _, newCert := writeCert(t, dir, "site", "localhost")
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+	require.NoError(t, os.Chtimes(keyFile, later, later))
+
+	roots.AddCert(newCert)
+	_, served = getTLS(t, srv, "localhost", roots)
+	assert.Equal(t, newCert.SerialNumber, served.SerialNumber)
+
+	// a broken file keeps the current certificate
+	require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0o600))
+	evenLater := later.Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, evenLater, evenLater))
+
+	_, served = getTLS(t, srv, "localhost", roots)
+	assert.Equal(t, newCert.SerialNumber, served.SerialNumber)
+	assert.Error(t, store.Reload())
+}
+
+func TestCertStoreReloadAll(t *testing.T) {
+	dir := t.TempDir()
+	aCert, aKey, _ := writeCert(t, dir, "a", "a.test")
+	bCert, bKey, _ := writeCert(t, dir, "b", "b.test")
+
+	store := NewCertStore()
+	store.CheckInterval = time.Hour
+	require.NoError(t, store.Add(aCert, aKey))
+	require.NoError(t, store.Add(bCert, bKey))
+
+	// the first entry breaks, the one after it is renewed
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.WriteFile(aCert, []byte("garbage"), 0o600))
+	require.NoError(t, os.Chtimes(aCert, later, later))
+	_, _, renewed := writeCert(t, dir, "b", "b.test")
+	require.NoError(t, os.Chtimes(bCert, later, later))
+	require.NoError(t, os.Chtimes(bKey, later, later))
+
+	err := store.Reload()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), aCert)
+
+	cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "b.test"})
+	require.NoError(t, err)
+	assert.Equal(t, renewed.SerialNumber, cert.Leaf.SerialNumber)
+
+	// the broken one is still served
+	cert, err = store.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.test"})
+	require.NoError(t, err)
+	assert.NotNil(t, cert)
+}
+
+func TestCertStoreConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, _ := writeCert(t, dir, "site", "localhost")
+
+	store := NewCertStore()
+	store.CheckInterval = 0
+	require.NoError(t, store.Add(certFile, keyFile))
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			for range 50 {
+				cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "localhost"})
+				assert.NoError(t, err)
+				assert.NotNil(t, cert)
+			}
+		})
+	}
+	wg.Go(func() {
+		for range 20 {
+			later := time.Now().Add(time.Minute)
+			os.Chtimes(certFile, later, later)
+			assert.NoError(t, store.Reload())
+		}
+	})
+	wg.Wait()
+}